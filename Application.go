@@ -3,10 +3,29 @@ package main
 import (
 	"Pong/core"
 	"Pong/logger"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	logger.Log.Init()
 	logger.Log.Info("Server launching..")
-	core.StartService()
+
+	//收到 SIGINT/SIGTERM 時優雅關閉伺服器
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	//關閉中再收到一次訊號則直接結束
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := core.StartService(ctx); err != nil {
+		logger.Log.Error(fmt.Sprintf("Server 啟動失敗: %v", err))
+		os.Exit(1)
+	}
 }
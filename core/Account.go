@@ -0,0 +1,360 @@
+package core
+
+import (
+	"Pong/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 帳號、密碼與暱稱的格式限制
+const MinPasswordLength = 6
+const MaxPasswordLength = 72 // bcrypt 只使用前 72 bytes
+const MaxNickNameLength = 20
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,20}$`)
+
+var ErrBadAccount = errors.New("invalid username, password or nickname")
+var ErrAccountExists = errors.New("account already exists")
+var ErrLoginFailed = errors.New("wrong username or password")
+var ErrNickNameTaken = errors.New("nickname already taken")
+
+// Account 玩家帳號，登入後玩家的暱稱、評分與戰績都保存在帳號中
+type Account struct {
+	Username     string    `json:"username"`
+	NickName     string    `json:"nickName"`
+	PasswordHash []byte    `json:"passwordHash"` // bcrypt(內含隨機 salt)
+	Rating       float64   `json:"rating"`
+	Wins         int       `json:"wins"`
+	Losses       int       `json:"losses"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// bindAccount 登入成功的帳號綁定到玩家，同一帳號已在其他連線登入時回覆 false
+type bindAccount struct {
+	player  *Player
+	account *Account
+	reply   chan bool
+}
+
+// CreateAccount 註冊新帳號，暱稱留空時使用帳號名稱，帳號名稱與暱稱都不分大小寫且不能重複
+func (s *Store) CreateAccount(username string, password string, nickName string) (*Account, error) {
+	nickName = strings.TrimSpace(nickName)
+	if nickName == "" {
+		nickName = username
+	}
+	if !usernamePattern.MatchString(username) || !validPassword(password) || !validNickName(nickName) {
+		return nil, ErrBadAccount
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	account := &Account{
+		Username:     username,
+		NickName:     nickName,
+		PasswordHash: hash,
+		Rating:       DefaultRating,
+		CreatedAt:    time.Now(),
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		accounts := tx.Bucket(accountBucket)
+		nickNames := tx.Bucket(nickNameBucket)
+		if accounts.Get(accountKey(username)) != nil {
+			return ErrAccountExists
+		}
+		if nickNames.Get(accountKey(nickName)) != nil {
+			return ErrNickNameTaken
+		}
+		if err := nickNames.Put(accountKey(nickName), accountKey(username)); err != nil {
+			return err
+		}
+		return putAccount(accounts, account)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Authenticate 以帳號密碼登入，帳號不存在與密碼錯誤都回傳 ErrLoginFailed
+func (s *Store) Authenticate(username string, password string) (*Account, error) {
+	account, err := s.account(username)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		return nil, ErrLoginFailed
+	}
+	return account, nil
+}
+
+// SetNickName 更換帳號的暱稱，釋放原本保留的暱稱
+func (s *Store) SetNickName(username string, nickName string) error {
+	nickName = strings.TrimSpace(nickName)
+	if !validNickName(nickName) {
+		return ErrBadAccount
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		accounts := tx.Bucket(accountBucket)
+		nickNames := tx.Bucket(nickNameBucket)
+
+		account, err := getAccount(accounts, username)
+		if err != nil || account == nil {
+			return ErrLoginFailed
+		}
+		owner := nickNames.Get(accountKey(nickName))
+		if owner != nil && string(owner) != string(accountKey(username)) {
+			return ErrNickNameTaken
+		}
+
+		if err := nickNames.Delete(accountKey(account.NickName)); err != nil {
+			return err
+		}
+		if err := nickNames.Put(accountKey(nickName), accountKey(username)); err != nil {
+			return err
+		}
+		account.NickName = nickName
+		return putAccount(accounts, account)
+	})
+}
+
+// NickNameReserved 暱稱是否已被某個帳號保留
+func (s *Store) NickNameReserved(nickName string) (bool, error) {
+	reserved := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		reserved = tx.Bucket(nickNameBucket).Get(accountKey(strings.TrimSpace(nickName))) != nil
+		return nil
+	})
+	return reserved, err
+}
+
+// SaveResult 保存一場比賽後的評分與勝敗
+func (s *Store) SaveResult(username string, rating float64, won bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		accounts := tx.Bucket(accountBucket)
+		account, err := getAccount(accounts, username)
+		if err != nil || account == nil {
+			return ErrLoginFailed
+		}
+		account.Rating = rating
+		if won {
+			account.Wins++
+		} else {
+			account.Losses++
+		}
+		return putAccount(accounts, account)
+	})
+}
+
+func (s *Store) account(username string) (*Account, error) {
+	var account *Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		account, err = getAccount(tx.Bucket(accountBucket), username)
+		return err
+	})
+	return account, err
+}
+
+func getAccount(accounts *bolt.Bucket, username string) (*Account, error) {
+	data := accounts.Get(accountKey(username))
+	if data == nil {
+		return nil, nil
+	}
+	account := &Account{}
+	if err := json.Unmarshal(data, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func putAccount(accounts *bolt.Bucket, account *Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return accounts.Put(accountKey(account.Username), data)
+}
+
+// accountKey 帳號名稱與暱稱不分大小寫
+func accountKey(name string) []byte {
+	return []byte(strings.ToLower(name))
+}
+
+func validPassword(password string) bool {
+	return len(password) >= MinPasswordLength && len(password) <= MaxPasswordLength
+}
+
+func validNickName(nickName string) bool {
+	n := utf8.RuneCountInString(nickName)
+	return n > 0 && n <= MaxNickNameLength
+}
+
+// register 註冊帳號後直接登入
+func (s *Server) register(player *Player, m *RegisterMsg) {
+	if !s.checkAccountRequest(player, m) {
+		return
+	}
+
+	account, err := s.store.CreateAccount(m.Username, m.Password, m.NickName)
+	if err != nil {
+		s.sendAccountError(player, err, m)
+		return
+	}
+	s.logger.Info(fmt.Sprintf(logger.AccountRegisteredMsg, account.Username, player.Id))
+	s.loginAccount(player, account, m)
+}
+
+// login 以帳號密碼登入，之後玩家的暱稱、評分與戰績都來自帳號
+func (s *Server) login(player *Player, m *LoginMsg) {
+	if !s.checkAccountRequest(player, m) {
+		return
+	}
+
+	account, err := s.store.Authenticate(m.Username, m.Password)
+	if err != nil {
+		s.sendAccountError(player, err, m)
+		return
+	}
+	s.loginAccount(player, account, m)
+}
+
+// checkAccountRequest 伺服器需啟用帳號，且玩家尚未登入、不在快速配對佇列中
+func (s *Server) checkAccountRequest(player *Player, msg Message) bool {
+	if s.store == nil {
+		s.sendError(player, ErrCodeNoAccounts, "accounts disabled", msg)
+		return false
+	}
+	if player.account != nil {
+		s.sendError(player, ErrCodeAlreadyLoggedIn, "already logged in", msg)
+		return false
+	}
+	return !s.rejectWhileMatching(player, msg)
+}
+
+// loginAccount 由大廳將帳號綁定到玩家，成功後回覆 AI
+func (s *Server) loginAccount(player *Player, account *Account, msg Message) {
+	reply := make(chan bool, 1)
+	if !s.toLobby(bindAccount{player: player, account: account, reply: reply}) {
+		return
+	}
+	var ok bool
+	select {
+	case ok = <-reply:
+	case <-s.jobCtx.Done():
+		return
+	}
+	if !ok {
+		s.sendError(player, ErrCodeAlreadyLoggedIn, fmt.Sprintf("account %s already logged in", account.Username), msg)
+		return
+	}
+
+	s.logger.Info(fmt.Sprintf(logger.AccountLoginMsg, account.Username, player.Id))
+	s.sendMsg(player, generateAccountInfoPayload(account))
+}
+
+// handleBindAccount 同一帳號只能在一條連線登入
+func (s *Server) handleBindAccount(e bindAccount) {
+	for _, other := range s.lobbyPlayer {
+		if other != e.player && other.account != nil && strings.EqualFold(other.account.Username, e.account.Username) {
+			e.reply <- false
+			return
+		}
+	}
+
+	player := e.player
+	player.account = e.account
+	player.NickName = e.account.NickName
+	player.Rating = e.account.Rating
+	e.reply <- true
+}
+
+// setNickName 登入的玩家更換帳號的暱稱，未登入的玩家不能使用已被帳號保留的暱稱，排隊中不能更換
+func (s *Server) setNickName(player *Player, m *PlayerNameMsg) {
+	//排隊中的玩家可能隨時被移入房間，由房間 goroutine 讀取暱稱
+	if s.rejectWhileMatching(player, m) {
+		return
+	}
+	//未啟用帳號時同樣限制長度，名字會轉送給其他玩家
+	name := strings.TrimSpace(m.Name)
+	if !validNickName(name) {
+		s.sendAccountError(player, ErrBadAccount, m)
+		return
+	}
+	if s.store == nil {
+		player.NickName = name
+		return
+	}
+
+	var err error
+	if player.account != nil {
+		err = s.store.SetNickName(player.account.Username, m.Name)
+	} else if reserved, lookupErr := s.store.NickNameReserved(m.Name); lookupErr != nil {
+		err = lookupErr
+	} else if reserved {
+		err = ErrNickNameTaken
+	}
+	if err != nil {
+		s.sendAccountError(player, err, m)
+		return
+	}
+
+	player.NickName = name
+	if player.account != nil {
+		player.account.NickName = player.NickName
+	}
+}
+
+// saveResults 分出勝負後保存登入玩家的評分與戰績，由房間 goroutine 呼叫
+func (s *Server) saveResults(winner *Player, loser *Player) {
+	if s.store == nil {
+		return
+	}
+	for _, player := range []*Player{winner, loser} {
+		account := player.account
+		if account == nil {
+			continue
+		}
+		won := player == winner
+		if err := s.store.SaveResult(account.Username, player.Rating, won); err != nil {
+			s.logger.Error(fmt.Sprintf(logger.StoreFailedMsg, err))
+			continue
+		}
+		account.Rating = player.Rating
+		if won {
+			account.Wins++
+		} else {
+			account.Losses++
+		}
+	}
+}
+
+// sendAccountError 將帳號操作的錯誤轉為錯誤代碼回覆
+func (s *Server) sendAccountError(player *Player, err error, msg Message) {
+	code, reason := ErrCodeStoreFailed, "store error"
+	switch {
+	case errors.Is(err, ErrBadAccount):
+		code, reason = ErrCodeBadAccount, err.Error()
+	case errors.Is(err, ErrAccountExists):
+		code, reason = ErrCodeAccountExists, err.Error()
+	case errors.Is(err, ErrLoginFailed):
+		code, reason = ErrCodeLoginFailed, err.Error()
+	case errors.Is(err, ErrNickNameTaken):
+		code, reason = ErrCodeNickNameTaken, err.Error()
+	default:
+		//資料庫的錯誤只記錄在 Log 中
+		s.logger.Error(fmt.Sprintf(logger.StoreFailedMsg, err))
+	}
+	s.sendError(player, code, reason, msg)
+}
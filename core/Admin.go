@@ -0,0 +1,110 @@
+package core
+
+import (
+	"Pong/logger"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminMatchesPath 管理介面查詢比賽紀錄的路徑，與 WebSocket gateway 共用同一個 port
+//
+//	GET /admin/matches?player=<帳號>&playerId=<未登入玩家 id>&from=<RFC3339>&to=<RFC3339>&limit=<筆數>
+//	Authorization: Bearer <ADMIN_TOKEN>
+const AdminMatchesPath = "/admin/matches"
+
+// 管理介面一次最多回傳的比賽紀錄筆數
+const DefaultAdminMatchLimit = 100
+const MaxAdminMatchLimit = 1000
+
+// adminMatchesResponse 管理介面回傳的比賽紀錄，由新到舊排列
+type adminMatchesResponse struct {
+	Matches []*MatchRecord `json:"matches"`
+}
+
+// handleAdminMatches 依玩家與結束時間查詢比賽紀錄，未設定 admin token 或未啟用資料庫時不提供
+func (s *Server) handleAdminMatches(w http.ResponseWriter, r *http.Request) {
+	if s.adminToken == "" || s.store == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := parseMatchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := s.store.Matches(query)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf(logger.StoreFailedMsg, err))
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []*MatchRecord{}
+	}
+	s.logger.Info(fmt.Sprintf(logger.AdminMatchQueryMsg, r.RemoteAddr, r.URL.RawQuery, len(records)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminMatchesResponse{Matches: records})
+}
+
+// adminAuthScheme Authorization header 必須以此開頭，只帶 token 不接受
+const adminAuthScheme = "Bearer "
+
+// isAdmin 檢查 Authorization header 中的 Bearer token，以固定時間比較避免由回應時間猜出 token
+func (s *Server) isAdmin(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, adminAuthScheme) {
+		return false
+	}
+	token := auth[len(adminAuthScheme):]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// parseMatchQuery 讀取查詢參數，時間使用 RFC3339(e.g. 2022-06-01T00:00:00+08:00)
+func parseMatchQuery(r *http.Request) (MatchQuery, error) {
+	values := r.URL.Query()
+	query := MatchQuery{
+		Username: values.Get("player"),
+		PlayerId: values.Get("playerId"),
+		Limit:    DefaultAdminMatchLimit,
+	}
+
+	var err error
+	if v := values.Get("from"); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > MaxAdminMatchLimit {
+			limit = MaxAdminMatchLimit
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
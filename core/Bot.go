@@ -0,0 +1,183 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 電腦玩家的難度
+const BotEasy = "easy"
+const BotMedium = "medium"
+const BotHard = "hard"
+
+// BotDifficulty 電腦玩家的反應時間、球拍速度上限與預測誤差
+type BotDifficulty struct {
+	ReactionDelay   time.Duration // 每隔多久重新判斷一次球的落點
+	MaxPaddleSpeed  float64       // 球拍移動速度上限，不超過房間的 PaddleSpeed
+	PredictionError float64       // 預測落點的最大誤差
+	Rating          float64       // 顯示用的評分，與電腦玩家的比賽不計分
+}
+
+// BotDifficulties 各難度的設定
+var BotDifficulties = map[string]BotDifficulty{
+	BotEasy:   {ReactionDelay: 400 * time.Millisecond, MaxPaddleSpeed: 200, PredictionError: 140, Rating: 1200},
+	BotMedium: {ReactionDelay: 200 * time.Millisecond, MaxPaddleSpeed: 300, PredictionError: 80, Rating: 1500},
+	BotHard:   {ReactionDelay: 80 * time.Millisecond, MaxPaddleSpeed: 450, PredictionError: 20, Rating: 1800},
+}
+
+// bot 電腦玩家的狀態，只由所在的房間 goroutine 存取
+type bot struct {
+	difficulty BotDifficulty
+	rand       *rand.Rand
+	thinkIn    float64 // 距離下次判斷落點的秒數
+	target     float64 // 球拍中央要移動到的高度
+	seq        int     // 送出的輸入序號
+}
+
+// botSession 電腦玩家沒有連線，送給它的訊息直接丟棄
+type botSession struct {
+	sessionMetadata
+}
+
+func (s *botSession) Send(msg Message) error            { return nil }
+func (s *botSession) Receive() (Message, error)         { return nil, ErrSessionClosed }
+func (s *botSession) SetReadDeadline(t time.Time) error { return nil }
+func (s *botSession) Close() error                      { return nil }
+func (s *botSession) RemoteAddr() string                { return "bot" }
+func (s *botSession) Codec() Codec                      { return binaryCodec{} }
+
+// newBotPlayer 產生電腦玩家，與一般玩家一樣以連續輸入移動球拍，並且永遠是準備狀態
+func newBotPlayer(difficulty string) *Player {
+	setting := BotDifficulties[difficulty]
+	return &Player{
+		NickName:        fmt.Sprintf("Bot (%s)", difficulty),
+		Id:              uuid.NewString(),
+		RoomReadyStatus: 1,
+		Rating:          setting.Rating,
+		Scene:           SceneRoom,
+		session:         &botSession{},
+		Features:        []string{FeatureContinuousInput},
+		maxPaddleSpeed:  setting.MaxPaddleSpeed,
+		bot: &bot{
+			difficulty: setting,
+			rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+			target:     windowHeight / 2,
+		},
+	}
+}
+
+// isBot 是否為電腦玩家
+func (p *Player) isBot() bool {
+	return p.bot != nil
+}
+
+// addBot 房間中的玩家加入電腦玩家當對手
+func (r *Room) addBot(player *Player, m *AddBotMsg) {
+	if _, ok := BotDifficulties[m.Difficulty]; !ok {
+		r.server.sendError(player, ErrCodeBadDifficulty, fmt.Sprintf("unknown bot difficulty %q", m.Difficulty), m)
+		return
+	}
+	if len(r.players) >= 2 {
+		//人數已滿 通知！
+		r.sendRoomFull(player, m)
+		return
+	}
+
+	botPlayer := newBotPlayer(m.Difficulty)
+	r.players = append(r.players, botPlayer)
+	botPlayer.setRoom(r)
+
+	r.updateLobby()
+	r.notifyRoomPlayerUpdateRoomDetail()
+	r.server.logger.Info(fmt.Sprintf(logger.BotAddedMsg, botPlayer.Id, m.Difficulty, r.RoomId))
+
+	//玩家已經準備好時直接開始倒數
+	if r.isAllReady() && r.countdown == nil && !r.server.isShuttingDown() {
+		r.prepareBattle()
+	}
+}
+
+// removeBot 移除房間中的電腦玩家，讓其他玩家可以進入
+func (r *Room) removeBot() {
+	for _, player := range r.players {
+		if player.isBot() {
+			r.removeRoomPlayer(player.Id)
+			player.setRoom(nil)
+
+			r.updateLobby()
+			r.notifyRoomPlayerUpdateRoomDetail()
+			r.server.logger.Info(fmt.Sprintf(logger.BotRemovedMsg, player.Id, r.RoomId))
+			return
+		}
+	}
+}
+
+// driveBots 電腦玩家依預測的落點決定要按住的方向，與 Client 送來的 BA 走相同的輸入處理
+func (r *Room) driveBots(dt float64) {
+	for _, player := range r.players {
+		if !player.isBot() {
+			continue
+		}
+		b := player.bot
+
+		//每隔反應時間才重新判斷一次，模擬人的反應延遲
+		b.thinkIn -= dt
+		if b.thinkIn <= 0 {
+			b.thinkIn = b.difficulty.ReactionDelay.Seconds()
+			b.target = r.predictBallRow(player) + (b.rand.Float64()*2-1)*b.difficulty.PredictionError
+		}
+
+		action := ActionIdle
+		diff := b.target - (player.Row + float64(player.Height)/2)
+		//差距小於一步的移動距離時放開，避免在目標附近來回抖動
+		deadZone := math.Max(r.paddleSpeed(player)*dt, 1)
+		if diff < -deadZone {
+			action = ActionUp
+		} else if diff > deadZone {
+			action = ActionDown
+		}
+
+		if action != heldAction(player) {
+			b.seq++
+			r.handleBattleAction(player, action, b.seq)
+		}
+	}
+}
+
+// predictBallRow 球到達此球拍時的高度(包含牆壁反彈)，球往對手方向移動時回到中央等待
+func (r *Room) predictBallRow(paddle *Player) float64 {
+	ball := r.Ball
+	if ball.VelCol == 0 {
+		return windowHeight / 2
+	}
+	t := (paddle.Col - ball.Col) / ball.VelCol
+	if t < 0 {
+		return windowHeight / 2
+	}
+
+	//將直線路徑折返到上下牆壁之間
+	row := math.Mod(ball.Row+ball.VelRow*t, 2*windowHeight)
+	if row < 0 {
+		row += 2 * windowHeight
+	}
+	if row > windowHeight {
+		row = 2*windowHeight - row
+	}
+	return row
+}
+
+// heldAction 球拍目前的速度對應的按住方向
+func heldAction(paddle *Player) string {
+	switch {
+	case paddle.VelRow < 0:
+		return ActionUp
+	case paddle.VelRow > 0:
+		return ActionDown
+	}
+	return ActionIdle
+}
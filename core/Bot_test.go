@@ -0,0 +1,33 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPredictBallRow(t *testing.T) {
+	paddle := &Player{GameObject: GameObject{Col: windowWidth - 20}}
+
+	cases := []struct {
+		name                     string
+		row, col, velRow, velCol float64
+		want                     float64
+	}{
+		{"straight", 100, 380, 0, 400, 100},
+		{"bounces off the bottom wall", 500, 380, 400, 400, 2*windowHeight - 900},
+		{"bounces off the top wall", 100, 380, -400, 400, 300},
+		{"moving away waits in the centre", 100, 380, 0, -400, windowHeight / 2},
+		{"vertical ball waits in the centre", 100, 380, 400, 0, windowHeight / 2},
+		{"stopped ball waits in the centre", 100, 380, 0, 0, windowHeight / 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Room{Ball: &Ball{GameObject: GameObject{Row: c.row, Col: c.col, VelRow: c.velRow, VelCol: c.velCol}}}
+			got := r.predictBallRow(paddle)
+			if math.IsNaN(got) || math.Abs(got-c.want) > collisionTolerance {
+				t.Errorf("predictBallRow = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
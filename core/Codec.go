@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const CodecBinary = "binary" // 長度前綴的二進位封包(預設)
+const CodecLegacy = "legacy" // 舊版 Unity Client 使用的 "~" 結尾字串
+const CodecJSON = "json"     // 每行一個 JSON 物件，給瀏覽器與工具使用
+
+// LegacyDetectTimeout 連線後若在此時間內沒有收到任何資料，視為等待 RL 的舊版 Client
+const LegacyDetectTimeout = 1 * time.Second
+
+// ErrNotSupportedByCodec 此訊息在該編碼中沒有對應格式(e.g. 舊版 Client 不認識的新訊息)
+var ErrNotSupportedByCodec = errors.New("message not supported by codec")
+
+// Codec 決定一條連線上訊息的切割與編碼方式
+type Codec interface {
+	Name() string
+	// ReadMessage 從 r 讀取下一個完整訊息，同一條連線需重複使用同一個 r
+	ReadMessage(r *bufio.Reader) (Message, error)
+	// EncodeMessage 將訊息編碼成可直接寫入連線的位元組
+	EncodeMessage(msg Message) ([]byte, error)
+}
+
+// detectCodec 依 Client 送來的第一個位元組決定此連線使用的編碼，不會消耗任何資料
+//
+//	0x00      => binary(長度前綴的第一個位元組)
+//	'{'       => json
+//	其他/沒有資料 => legacy(舊版 Client 連線後會先等待 Server 傳送 RL)
+func detectCodec(conn net.Conn, reader *bufio.Reader) Codec {
+	conn.SetReadDeadline(time.Now().Add(LegacyDetectTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	b, err := reader.Peek(1)
+	if err != nil {
+		return legacyCodec{}
+	}
+
+	switch b[0] {
+	case 0x00:
+		return binaryCodec{}
+	case '{':
+		return jsonCodec{}
+	}
+	return legacyCodec{}
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return CodecBinary }
+
+func (binaryCodec) ReadMessage(r *bufio.Reader) (Message, error) {
+	return DecodeFrame(r)
+}
+
+func (binaryCodec) EncodeMessage(msg Message) ([]byte, error) {
+	return EncodeFrame(msg)
+}
+
+// jsonEnvelope JSON 編碼的外層，type 為兩字 Header，body 為訊息本身
+type jsonEnvelope struct {
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return CodecJSON }
+
+func (jsonCodec) ReadMessage(r *bufio.Reader) (Message, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, ErrFrameTooLarge
+	}
+	//最後一個訊息可以沒有換行(e.g. 一個 WebSocket 訊息只有一個 JSON)
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope jsonEnvelope
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return nil, &DecodeError{Err: fmt.Errorf("%w: %v", ErrMalformedBody, err)}
+	}
+
+	msgType, ok := parseMsgType(envelope.Type)
+	if !ok {
+		return nil, &DecodeError{Err: fmt.Errorf("%w: %q", ErrUnknownMsgType, envelope.Type)}
+	}
+	msg := newMessage(msgType)
+	if msg == nil {
+		return nil, &DecodeError{MsgType: msgType, Err: ErrUnknownMsgType}
+	}
+
+	if len(envelope.Body) > 0 {
+		if err := json.Unmarshal(envelope.Body, msg); err != nil {
+			return nil, &DecodeError{MsgType: msgType, Err: fmt.Errorf("%w: %v", ErrMalformedBody, err)}
+		}
+	}
+	return msg, nil
+}
+
+func (jsonCodec) EncodeMessage(msg Message) ([]byte, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(jsonEnvelope{Type: msg.Type().String(), Body: body})
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// parseMsgType 將兩字 Header 字串轉為 MsgType
+func parseMsgType(header string) (MsgType, bool) {
+	if len(header) != 2 {
+		return 0, false
+	}
+	return MsgType(header[0])<<8 | MsgType(header[1]), true
+}
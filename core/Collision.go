@@ -0,0 +1,110 @@
+package core
+
+import "math"
+
+// maxImpactsPerStep 一步中最多處理的碰撞次數，避免球卡在角落時無限反彈
+const maxImpactsPerStep = 4
+
+// impactEpsilon 兩個碰撞時間相差小於此值時視為同時發生(e.g. 同時撞到牆壁與球拍的角落)
+const impactEpsilon = 1e-9
+
+// impact 球在這一步中最先碰到的牆壁或球拍
+type impact struct {
+	t      float64 // 碰撞前經過的秒數
+	wall   float64 // 撞到的牆壁(-1 上、1 下)，沒有撞到牆壁時為 0
+	paddle *Player // 撞到的球拍，沒有撞到球拍時為 nil
+	dir    float64 // 撞到球拍後的水平方向(1 向右、-1 向左)
+}
+
+// moveBall 以連續碰撞偵測移動球 dt 秒，球的路徑與牆壁或球拍相交時在碰撞點反彈，不會因速度太快而穿過
+func (r *Room) moveBall(dt float64) {
+	ball := r.Ball
+	remaining := dt
+
+	for i := 0; i < maxImpactsPerStep && remaining > 0; i++ {
+		hit := r.nextImpact(remaining)
+		if hit == nil {
+			break
+		}
+
+		//移動到碰撞點後反彈，剩下的時間以反彈後的速度繼續移動
+		ball.Col += ball.VelCol * hit.t
+		ball.Row += ball.VelRow * hit.t
+		remaining -= hit.t
+
+		if hit.paddle != nil {
+			r.deflect(hit.paddle, hit.dir)
+		}
+		if hit.wall != 0 {
+			//同時撞到球拍時，球拍決定的角度仍需離開牆壁
+			ball.VelRow = -hit.wall * math.Abs(ball.VelRow)
+		}
+	}
+
+	ball.Col += ball.VelCol * remaining
+	ball.Row += ball.VelRow * remaining
+
+	//碰撞次數已達上限時剩下的路徑沒有檢查碰撞，球仍需留在上下牆壁之間並離開牆壁
+	if ball.Row < 0 {
+		ball.Row = 0
+		ball.VelRow = math.Abs(ball.VelRow)
+	} else if ball.Row > windowHeight {
+		ball.Row = windowHeight
+		ball.VelRow = -math.Abs(ball.VelRow)
+	}
+}
+
+// nextImpact 找出球在 dt 秒內最先碰到的牆壁或球拍，都沒有碰到時回傳 nil
+func (r *Room) nextImpact(dt float64) *impact {
+	ball := r.Ball
+	var hit *impact
+
+	consider := func(candidate impact) {
+		switch {
+		case candidate.t < 0 || candidate.t > dt:
+			return
+		case hit == nil || candidate.t < hit.t-impactEpsilon:
+			hit = &candidate
+		case candidate.t <= hit.t+impactEpsilon:
+			//同時發生的碰撞合併處理
+			if candidate.wall != 0 {
+				hit.wall = candidate.wall
+			}
+			if candidate.paddle != nil {
+				hit.paddle = candidate.paddle
+				hit.dir = candidate.dir
+			}
+		}
+	}
+
+	//上下牆壁
+	if ball.VelRow < 0 {
+		consider(impact{t: (0 - ball.Row) / ball.VelRow, wall: -1})
+	} else if ball.VelRow > 0 {
+		consider(impact{t: (windowHeight - ball.Row) / ball.VelRow, wall: 1})
+	}
+
+	//左右球拍的擊球面，球已經越過擊球面時不會再被擊回
+	player1 := r.players[0]
+	player2 := r.players[1]
+	if ball.VelCol < 0 && ball.Col >= player1.Col {
+		if t, ok := paddleImpact(ball, player1, (player1.Col-ball.Col)/ball.VelCol); ok {
+			consider(impact{t: t, paddle: player1, dir: 1})
+		}
+	} else if ball.VelCol > 0 && ball.Col <= player2.Col {
+		if t, ok := paddleImpact(ball, player2, (player2.Col-ball.Col)/ball.VelCol); ok {
+			consider(impact{t: t, paddle: player2, dir: -1})
+		}
+	}
+
+	return hit
+}
+
+// paddleImpact 球在 t 秒後到達球拍的擊球面，檢查當時的高度是否在球拍範圍內(包含兩端的角落)
+func paddleImpact(ball *Ball, paddle *Player, t float64) (float64, bool) {
+	row := ball.Row + ball.VelRow*t
+	if row < paddle.Row || row > paddle.Row+float64(paddle.Height) {
+		return 0, false
+	}
+	return t, true
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+// collisionPhysics 測試用的物理設定，球速上限夠高，讓每次擊中球拍都增加 SpeedIncrement
+var collisionPhysics = Physics{
+	SpeedIncrement: 100,
+	MaxSpeed:       5000,
+	MaxBounceAngle: 60,
+}
+
+const collisionTolerance = 1e-6
+
+// 左邊球拍的擊球面在 Col 0，右邊在 Col windowWidth-20(780)，球拍高度 PaddleHeight(150)
+func newCollisionRoom(row, col, velRow, velCol, paddle1Row, paddle2Row float64) *Room {
+	player1 := &Player{GameObject: GameObject{Col: 0, Row: paddle1Row, Width: 1, Height: PaddleHeight}}
+	player2 := &Player{GameObject: GameObject{Col: windowWidth - 20, Row: paddle2Row, Width: 1, Height: PaddleHeight}}
+	ball := &Ball{
+		GameObject: GameObject{Row: row, Col: col, VelRow: velRow, VelCol: velCol},
+		speed:      math.Hypot(velRow, velCol),
+	}
+	return &Room{players: []*Player{player1, player2}, Ball: ball, physics: collisionPhysics}
+}
+
+func TestMoveBall(t *testing.T) {
+	sin60, cos60 := math.Sin(math.Pi/3), math.Cos(math.Pi/3)
+	cornerSpeed := math.Hypot(1000, 1000) + collisionPhysics.SpeedIncrement
+
+	cases := []struct {
+		name string
+		//球的初始狀態
+		row, col, velRow, velCol float64
+		//兩個球拍的上緣
+		paddle1Row, paddle2Row float64
+		dt                     float64
+		//經過 dt 後球的狀態與比數
+		wantRow, wantCol, wantVelRow, wantVelCol float64
+		wantScore                                [2]int
+	}{
+		{
+			//每步移動 130，遠大於球拍寬度，在 t=0.04 擊中右邊球拍中央後水平彈回
+			name: "fast ball hits paddle instead of tunnelling",
+			row:  300, col: 700, velRow: 0, velCol: 2000,
+			paddle1Row: 0, paddle2Row: 225,
+			dt:      0.065,
+			wantRow: 300, wantCol: 780 - 2100*0.025, wantVelRow: 0, wantVelCol: -2100,
+		},
+		{
+			//t=0.1 同時到達下方牆壁與右邊球拍的下緣，以最大角度彈回並離開牆壁
+			name: "wall and paddle in the same step",
+			row:  500, col: 680, velRow: 1000, velCol: 1000,
+			paddle1Row: 0, paddle2Row: 450,
+			dt:         0.15,
+			wantRow:    600 - cornerSpeed*sin60*0.05,
+			wantCol:    780 - cornerSpeed*cos60*0.05,
+			wantVelRow: -cornerSpeed * sin60,
+			wantVelCol: -cornerSpeed * cos60,
+		},
+		{
+			//擊中球拍上緣，以最大角度往上彈回
+			name: "paddle edge deflects at max angle",
+			row:  200, col: 100, velRow: 0, velCol: -1000,
+			paddle1Row: 200, paddle2Row: 0,
+			dt:      0.1,
+			wantRow: 200, wantCol: 0, wantVelRow: -1100 * sin60, wantVelCol: 1100 * cos60,
+		},
+		{
+			//擊中球拍中央，水平彈回
+			name: "paddle centre deflects straight",
+			row:  200, col: 100, velRow: 0, velCol: -1000,
+			paddle1Row: 125, paddle2Row: 0,
+			dt:      0.15,
+			wantRow: 200, wantCol: 1100 * 0.05, wantVelRow: 0, wantVelCol: 1100,
+		},
+		{
+			//球拍在 100~250，球在 500 的高度通過，左邊出界由右邊玩家得分
+			name: "clean miss scores",
+			row:  500, col: 50, velRow: 0, velCol: -1000,
+			paddle1Row: 100, paddle2Row: 0,
+			dt:      0.1,
+			wantRow: 500, wantCol: -50, wantVelRow: 0, wantVelCol: -1000,
+			wantScore: [2]int{0, 1},
+		},
+		{
+			//垂直來回反彈，t=0.05, 0.15, 0.25, 0.35 各碰一次牆壁後達到 maxImpactsPerStep(4)，
+			//剩下的 0.65 秒不再檢查碰撞，停在下方牆壁並往上離開
+			name: "impacts per step are capped",
+			row:  300, col: 400, velRow: 6000, velCol: 0,
+			paddle1Row: 0, paddle2Row: 0,
+			dt:      1,
+			wantRow: windowHeight, wantCol: 400, wantVelRow: -6000, wantVelCol: 0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newCollisionRoom(c.row, c.col, c.velRow, c.velCol, c.paddle1Row, c.paddle2Row)
+
+			//與 updateState 相同，移動後檢查是否出界
+			r.moveBall(c.dt)
+			if r.isBallOutSide() {
+				r.calculateScore()
+			}
+
+			ball := r.Ball
+			if ball.Row < 0 || ball.Row > windowHeight {
+				t.Errorf("ball.Row = %v, outside the field [0, %d]", ball.Row, windowHeight)
+			}
+			got := []float64{ball.Row, ball.Col, ball.VelRow, ball.VelCol}
+			want := []float64{c.wantRow, c.wantCol, c.wantVelRow, c.wantVelCol}
+			for i, field := range []string{"Row", "Col", "VelRow", "VelCol"} {
+				if math.Abs(got[i]-want[i]) > collisionTolerance {
+					t.Errorf("ball.%s = %v, want %v", field, got[i], want[i])
+				}
+			}
+
+			score := [2]int{r.players[0].CurrentScore, r.players[1].CurrentScore}
+			if score != c.wantScore {
+				t.Errorf("score = %v, want %v", score, c.wantScore)
+			}
+		})
+	}
+}
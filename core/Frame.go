@@ -0,0 +1,220 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// 封包格式(Big Endian):
+//
+//	| length uint32 | type uint16 | body ... |
+//
+// length 為 type + body 的位元組長度，type 為兩個 ASCII 字元組成的 Header(e.g. 'C''R')，
+// body 由各訊息自行以 bodyWriter 編碼，字串一律為 uint16 長度 + UTF-8 內容，
+// 因此名稱中出現 "~", "," 或 "&" 都不會破壞封包。
+
+const frameLengthSize = 4
+const frameTypeSize = 2
+
+// MaxFrameSize 單一封包(type + body)的最大長度
+const MaxFrameSize = 64 * 1024
+
+var ErrFrameTooLarge = errors.New("frame too large")
+var ErrFrameTooSmall = errors.New("frame too small")
+var ErrUnknownMsgType = errors.New("unknown message type")
+var ErrMalformedBody = errors.New("malformed message body")
+
+// ErrMessageTooLarge 要送出的訊息無法編碼(字串超過 65535 bytes 或封包超過 MaxFrameSize)，只略過此訊息
+var ErrMessageTooLarge = errors.New("message too large to encode")
+
+// DecodeError 封包本身完整但內容無法解析，連線仍可繼續使用
+type DecodeError struct {
+	MsgType MsgType // 造成錯誤的封包類型，無法判斷時為 0
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	if e.MsgType == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.MsgType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeFrame 將訊息編碼成一個完整封包，字串或封包超過長度上限時回傳 ErrMessageTooLarge
+func EncodeFrame(msg Message) ([]byte, error) {
+	w := &bodyWriter{}
+	msg.marshal(w)
+	if w.err != nil {
+		return nil, fmt.Errorf("%s: %w", msg.Type(), w.err)
+	}
+	if frameTypeSize+len(w.buf) > MaxFrameSize {
+		return nil, fmt.Errorf("%s: %w (%d bytes)", msg.Type(), ErrMessageTooLarge, frameTypeSize+len(w.buf))
+	}
+
+	frame := make([]byte, frameLengthSize+frameTypeSize, frameLengthSize+frameTypeSize+len(w.buf))
+	binary.BigEndian.PutUint32(frame[0:], uint32(frameTypeSize+len(w.buf)))
+	binary.BigEndian.PutUint16(frame[frameLengthSize:], uint16(msg.Type()))
+	return append(frame, w.buf...), nil
+}
+
+// DecodeFrame 從 r 讀取一個完整封包並解出訊息
+// 呼叫端需對同一條連線重複使用同一個 r(e.g. *bufio.Reader)，否則會遺失已緩衝的資料
+func DecodeFrame(r io.Reader) (Message, error) {
+	var lengthBuf [frameLengthSize]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length < frameTypeSize {
+		return nil, ErrFrameTooSmall
+	}
+	if length > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	msgType := MsgType(binary.BigEndian.Uint16(frame))
+	msg := newMessage(msgType)
+	if msg == nil {
+		return nil, &DecodeError{MsgType: msgType, Err: ErrUnknownMsgType}
+	}
+
+	br := &bodyReader{buf: frame[frameTypeSize:]}
+	msg.unmarshal(br)
+	if br.err != nil {
+		return nil, &DecodeError{MsgType: msgType, Err: br.err}
+	}
+	return msg, nil
+}
+
+// bodyWriter 寫入失敗後會記住第一個錯誤，由 EncodeFrame 回傳
+type bodyWriter struct {
+	buf []byte
+	err error
+}
+
+func (w *bodyWriter) putInt(v int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(int32(v)))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *bodyWriter) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+// putString 超過 uint16 長度的字串不截斷(可能切開 UTF-8 字元)，改為編碼失敗
+func (w *bodyWriter) putString(s string) {
+	if len(s) > math.MaxUint16 {
+		w.fail(ErrMessageTooLarge)
+		return
+	}
+	w.putUint16(uint16(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *bodyWriter) putStrings(list []string) {
+	w.putCount(len(list))
+	for _, s := range list {
+		w.putString(s)
+	}
+}
+
+func (w *bodyWriter) putCount(n int) {
+	if n > math.MaxUint16 {
+		w.fail(ErrMessageTooLarge)
+		return
+	}
+	w.putUint16(uint16(n))
+}
+
+func (w *bodyWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *bodyWriter) putUint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+// bodyReader 讀取失敗後會記住第一個錯誤，之後的讀取都回傳零值
+type bodyReader struct {
+	buf []byte
+	err error
+}
+
+func (r *bodyReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = ErrMalformedBody
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *bodyReader) int() int {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return int(int32(binary.BigEndian.Uint32(b)))
+}
+
+func (r *bodyReader) int64() int64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// more 是否還有未讀取的欄位，用於讀取後來才附加在訊息最後的欄位
+func (r *bodyReader) more() bool {
+	return r.err == nil && len(r.buf) > 0
+}
+
+func (r *bodyReader) string() string {
+	b := r.take(2)
+	if b == nil {
+		return ""
+	}
+	return string(r.take(int(binary.BigEndian.Uint16(b))))
+}
+
+func (r *bodyReader) count() int {
+	b := r.take(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *bodyReader) strings() []string {
+	n := r.count()
+	list := make([]string, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		list = append(list, r.string())
+	}
+	return list
+}
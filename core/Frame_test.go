@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	//舊版以 "~", "," 與 "&" 分隔欄位，新封包中的名稱可以包含這些字元
+	msgs := []Message{
+		&PlayerNameMsg{Name: "a~b,c&d"},
+		&PlayerNameMsg{Name: "小明🏓"},
+		&CreateRoomMsg{RoomName: "房間~1,2&3"},
+		&RoomDetailMsg{RoomId: "r1", RoomName: "對戰,&~", Players: []RoomPlayerInfo{
+			{PlayerId: "p1", NickName: "玩家~一", ReadyStatus: 1, PingMs: 30, JitterMs: 2, Rating: 1200},
+			{PlayerId: "p2", NickName: "ünïcødé,&", ReadyStatus: 0, PingMs: -1, JitterMs: -1, Rating: 1000},
+		}},
+		&RoomListMsg{Rooms: []RoomInfo{
+			{RoomId: "r1", RoomName: "a&b", CreateDate: "2022-06-01", PlayerCount: 1, RoomStatus: RoomStatusWaiting, SpectatorCount: 3},
+		}},
+	}
+
+	//所有封包寫入同一個串流，確認讀取時不會吃到下一個封包
+	var stream bytes.Buffer
+	for _, msg := range msgs {
+		frame, err := EncodeFrame(msg)
+		if err != nil {
+			t.Fatalf("EncodeFrame(%T): %v", msg, err)
+		}
+		stream.Write(frame)
+	}
+	for _, want := range msgs {
+		got, err := DecodeFrame(&stream)
+		if err != nil {
+			t.Fatalf("DecodeFrame(%T): %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DecodeFrame = %+v, want %+v", got, want)
+		}
+	}
+	if _, err := DecodeFrame(&stream); err != io.EOF {
+		t.Errorf("DecodeFrame at end of stream = %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeFrameErrors(t *testing.T) {
+	valid, err := EncodeFrame(&CreateRoomMsg{RoomName: "房間"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := func(length uint32) []byte {
+		b := make([]byte, frameLengthSize)
+		binary.BigEndian.PutUint32(b, length)
+		return b
+	}
+	//長度只涵蓋到字串長度欄位，字串內容被截掉
+	shortBody := append(header(frameTypeSize+2), valid[frameLengthSize:frameLengthSize+frameTypeSize+2]...)
+
+	cases := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"oversized frame", header(MaxFrameSize + 1), ErrFrameTooLarge},
+		{"frame without type", header(1), ErrFrameTooSmall},
+		{"truncated length", valid[:2], io.ErrUnexpectedEOF},
+		{"truncated frame", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		{"truncated body", shortBody, ErrMalformedBody},
+		{"unknown type", append(header(frameTypeSize), 'Z', 'Z'), ErrUnknownMsgType},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg, err := DecodeFrame(bytes.NewReader(c.frame))
+			if !errors.Is(err, c.want) {
+				t.Fatalf("DecodeFrame = %v, %v, want error %v", msg, err, c.want)
+			}
+		})
+	}
+}
+
+func TestEncodeFrameTooLarge(t *testing.T) {
+	//超過 uint16 長度的字串不截斷
+	long := strings.Repeat("名", math.MaxUint16/3+1)
+	if _, err := EncodeFrame(&PlayerNameMsg{Name: long}); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("EncodeFrame(long name) error = %v, want %v", err, ErrMessageTooLarge)
+	}
+
+	//每個欄位都合法，但整個封包超過 MaxFrameSize
+	rooms := make([]RoomInfo, 0, 1000)
+	for i := 0; i < cap(rooms); i++ {
+		rooms = append(rooms, RoomInfo{RoomId: strings.Repeat("r", 36), RoomName: strings.Repeat("n", MaxRoomNameLength)})
+	}
+	if _, err := EncodeFrame(&RoomListMsg{Rooms: rooms}); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("EncodeFrame(large room list) error = %v, want %v", err, ErrMessageTooLarge)
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"time"
+)
+
+const SendInterval = TickInterval // 預設傳送戰鬥狀態給玩家的間隔
+
+// MaxCatchUpSteps 一次 tick 最多補算的模擬步數，落後更多時捨棄，避免越算越慢
+const MaxCatchUpSteps = 5
+
+// TickStatsInterval 戰鬥中記錄 tick 統計的間隔
+const TickStatsInterval = 10 * time.Second
+
+// gameLoop 固定步長的遊戲迴圈，以累積的實際經過時間決定要模擬幾步，不受傳送花費的時間影響
+type gameLoop struct {
+	step         time.Duration // 每一步模擬的時間
+	sendInterval time.Duration
+
+	ticker      *time.Ticker
+	lastTick    time.Time
+	lastSend    time.Time
+	accumulator time.Duration
+
+	stats tickStats
+}
+
+// tickStats 實際 tick 間隔的統計，用來觀察房間 goroutine 是否跟得上
+type tickStats struct {
+	since    time.Time
+	ticks    int
+	steps    int
+	sends    int
+	late     int // 間隔超過兩倍步長的次數
+	dropped  int // 落後太多而捨棄的步數
+	sum      time.Duration
+	min, max time.Duration
+}
+
+func newGameLoop(step, sendInterval time.Duration) *gameLoop {
+	now := time.Now()
+	return &gameLoop{
+		step:         step,
+		sendInterval: sendInterval,
+		ticker:       time.NewTicker(step),
+		lastTick:     now,
+		lastSend:     now,
+		stats:        tickStats{since: now},
+	}
+}
+
+// advance 累積這次 tick 經過的時間，回傳需要模擬的步數
+func (l *gameLoop) advance(now time.Time) int {
+	elapsed := now.Sub(l.lastTick)
+	l.lastTick = now
+	l.accumulator += elapsed
+	l.stats.record(elapsed, l.step)
+
+	steps := int(l.accumulator / l.step)
+	if steps > MaxCatchUpSteps {
+		l.stats.dropped += steps - MaxCatchUpSteps
+		steps = MaxCatchUpSteps
+		l.accumulator = 0
+	} else {
+		l.accumulator -= time.Duration(steps) * l.step
+	}
+	l.stats.steps += steps
+	return steps
+}
+
+// shouldSend 是否已到傳送時間，以排定的時間累加避免傳送頻率因 tick 誤差而偏低
+func (l *gameLoop) shouldSend(now time.Time) bool {
+	//tick 可能比排定的時間早一點點到達，容許半步的誤差
+	if now.Sub(l.lastSend) < l.sendInterval-l.step/2 {
+		return false
+	}
+	l.lastSend = l.lastSend.Add(l.sendInterval)
+	if now.Sub(l.lastSend) >= l.sendInterval {
+		//落後超過一次傳送間隔時不補送，從現在重新排定
+		l.lastSend = now
+	}
+	l.stats.sends++
+	return true
+}
+
+func (l *gameLoop) stop() {
+	l.ticker.Stop()
+}
+
+func (t *tickStats) record(elapsed, step time.Duration) {
+	if t.ticks == 0 || elapsed < t.min {
+		t.min = elapsed
+	}
+	if elapsed > t.max {
+		t.max = elapsed
+	}
+	if elapsed > 2*step {
+		t.late++
+	}
+	t.ticks++
+	t.sum += elapsed
+}
+
+func (t *tickStats) String() string {
+	var avg time.Duration
+	if t.ticks > 0 {
+		avg = t.sum / time.Duration(t.ticks)
+	}
+	return fmt.Sprintf("%d 次 tick, 模擬 %d 步, 傳送 %d 次, 間隔 平均 %s 最小 %s 最大 %s, 延遲 %d 次, 捨棄 %d 步",
+		t.ticks, t.steps, t.sends, avg, t.min, t.max, t.late, t.dropped)
+}
+
+// updateBattle 依經過時間以固定步長更新遊戲狀態，並依傳送間隔傳送給玩家，分出勝負時結束戰鬥
+func (r *Room) updateBattle(now time.Time) {
+	loop := r.loop
+
+	for i := loop.advance(now); i > 0; i-- {
+		//檢查房間狀態(是否已分出勝負)
+		r.simTick++
+		if r.updateState(loop.step.Seconds()) == false {
+			r.finishBattle()
+			return
+		}
+	}
+
+	if loop.shouldSend(now) {
+		//斷線的玩家等待重連，重連後會收到完整狀態
+		for _, player := range r.players {
+			if !player.Disconnected {
+				r.server.sendGameState(player, r)
+			}
+		}
+		for _, spectator := range r.spectators {
+			r.server.sendGameState(spectator, r)
+		}
+	}
+
+	if now.Sub(loop.stats.since) >= TickStatsInterval {
+		r.logTickStats(now)
+	}
+}
+
+// logTickStats 記錄這段期間的 tick 統計並重新開始計算
+func (r *Room) logTickStats(now time.Time) {
+	stats := &r.loop.stats
+	if stats.ticks > 0 {
+		r.server.logger.Info(fmt.Sprintf(logger.TickStatsMsg, r.RoomId, now.Sub(stats.since).Round(time.Millisecond), stats))
+	}
+	*stats = tickStats{since: now}
+}
@@ -1,46 +1,129 @@
 package core
 
-import "net"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type GameObject struct {
-	Row, Col       int
+	Row, Col       float64
 	Width, Height  int
-	VelRow, VelCol int
+	VelRow, VelCol float64 // 每秒移動的距離
 	Symbol         rune
 }
 
 type Ball struct {
 	GameObject
+	speed float64 // 目前的球速，擊中球拍後增加，每回合重設
 }
 
 type Player struct {
 	GameObject
 	NickName        string
-	IdAkaIpAddress  string
+	Id              string // Server 產生的玩家 id，不含任何連線資訊
+	SessionToken    string // 只交給玩家本人的憑證
 	RightOrLeft     string
 	CurrentScore    int
+	Rating          float64 // Elo 評分，每場分出勝負的比賽後更新
 	RoomReadyStatus int
 	Scene           string
-	Conn            *net.Conn
-	HearBeatCount   int
+	lastSeen        int64 // 最後收到此玩家訊息的時間(UnixNano)，只透過 atomic 存取
+	matching        int32 // 在快速配對佇列中(1)，只透過 atomic 存取
+
+	// 其餘欄位只由玩家所在的房間 goroutine(不在房間時由玩家自己的連線 goroutine)修改
+	// session 與 room 會被其他 goroutine 讀取，需透過 mutex 存取
+	mutex   sync.Mutex
+	session Session
+	room    *Room
+
+	account *Account // 登入的帳號，未登入時為 nil，只在大廳中綁定
+
+	ProtocolVersion int      // 握手協商出的協定版本，重連時換成新連線的版本
+	Features        []string // 握手協商出的雙方共同支援功能，重連時會更新，需透過 hasFeature 讀取
+
+	lastInputSeq   int     // 已處理的最後一個 BA 序號
+	maxPaddleSpeed float64 // 球拍移動速度上限，0 代表使用房間的 PaddleSpeed
+
+	bot *bot // 電腦玩家的狀態，一般玩家為 nil
+
+	latency latency // PI/PO 量測的延遲
+	pingSeq int     // 最後送出的 PI 序號，只由大廳 goroutine 存取
+
+	Disconnected   bool        // 戰鬥中斷線，等待重連，由房間 goroutine 透過 setDisconnected 修改，其他 goroutine 需透過 isDisconnected 讀取
+	reconnectTimer *time.Timer // 重連時限
 }
 
 func (p *Player) SetScene(scene string) {
 	p.Scene = scene
 }
 
+// PaddleStep 舊版輸入每個 BA 移動球拍的距離
+const PaddleStep = 50
+
 func (p *Player) MoveUp() {
-	p.Row -= 50
+	p.Row -= PaddleStep
 }
 
 func (p *Player) MoveDown() {
-	p.Row += 50
+	p.Row += PaddleStep
+}
+
+// hasFeature 握手時是否協商出此功能
+func (p *Player) hasFeature(feature string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return containsString(p.Features, feature)
+}
+
+// LastSeen 最後收到此玩家任何訊息(包含心跳封包)的時間
+func (p *Player) LastSeen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&p.lastSeen))
+}
+
+// touch 收到此玩家的訊息，更新最後活動時間
+func (p *Player) touch() {
+	atomic.StoreInt64(&p.lastSeen, time.Now().UnixNano())
+}
+
+// Session 玩家目前的連線(重連後會換成新的連線)
+func (p *Player) Session() Session {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.session
+}
+
+// resumeSession 重連後換成新的連線，Client 可能已更新版本，改用新連線協商出的協定版本與功能
+func (p *Player) resumeSession(session Session, version int, features []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.session = session
+	p.ProtocolVersion = version
+	p.Features = features
+}
+
+// currentRoom 玩家所在的房間，在大廳時為 nil
+func (p *Player) currentRoom() *Room {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.room
+}
+
+func (p *Player) setRoom(room *Room) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.room = room
 }
 
-func (p *Player) Heartbeat() {
-	p.HearBeatCount += 1
+// isDisconnected 戰鬥中斷線、等待重連
+func (p *Player) isDisconnected() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.Disconnected
 }
 
-func (p *Player) resetHeartbeat() {
-	p.HearBeatCount = 0
+func (p *Player) setDisconnected(disconnected bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Disconnected = disconnected
 }
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const ProtocolVersion = 1    // Server 目前使用的協定版本
+const MinProtocolVersion = 1 // Server 仍可相容的最低協定版本
+
+const HandshakeTimeout = 5 * time.Second // 預設連線後需在此時間內送出 HI
+
+// 握手被拒絕的原因
+const RejectBadHello = 1           // 第一個封包不是 HI 或無法解析
+const RejectUnsupportedVersion = 2 // 協定版本不相容
+const RejectServerFull = 3         // 伺服器已滿
+
+// FeatureContinuousInput BA 傳送的是按住的方向(上、下、放開)，由 Server 每一步以固定速度移動球拍
+// 沒有此功能的 Client 每個 BA 讓球拍直接移動 PaddleStep
+const FeatureContinuousInput = "continuous-input"
+
+// serverFeatures Server 支援的功能，握手時與 Client 取交集
+var serverFeatures = []string{FeatureContinuousInput, FeaturePing}
+
+// handshake 等待 Client 的 HI 封包並協商版本與功能
+// 成功時回傳 Client 的 HI 與要送給 Client 的 WC，失敗時回傳 HR
+func (s *Server) handshake(session Session) (*HelloMsg, *WelcomeMsg, *HelloRejectMsg) {
+	session.SetReadDeadline(time.Now().Add(s.handshakeTimeout))
+	defer session.SetReadDeadline(time.Time{})
+
+	msg, err := session.Receive()
+	if err != nil {
+		return nil, nil, generateHelloRejectPayload(RejectBadHello, fmt.Sprintf("invalid hello: %v", err))
+	}
+
+	hello, ok := msg.(*HelloMsg)
+	if !ok {
+		return nil, nil, generateHelloRejectPayload(RejectBadHello, fmt.Sprintf("expected %s, got %s", HelloHeader, msg.Type()))
+	}
+
+	version, ok := negotiateVersion(hello.Version)
+	if !ok {
+		return nil, nil, generateHelloRejectPayload(RejectUnsupportedVersion,
+			fmt.Sprintf("protocol version %d not supported (server supports %d-%d)", hello.Version, MinProtocolVersion, ProtocolVersion))
+	}
+
+	return hello, generateWelcomePayload(version, negotiateFeatures(hello.Features), s.tickInterval), nil
+}
+
+// negotiateVersion 取雙方都支援的最高版本
+func negotiateVersion(clientVersion int) (int, bool) {
+	if clientVersion < MinProtocolVersion {
+		return 0, false
+	}
+	if clientVersion > ProtocolVersion {
+		return ProtocolVersion, true
+	}
+	return clientVersion, true
+}
+
+func negotiateFeatures(clientFeatures []string) []string {
+	features := make([]string, 0, len(serverFeatures))
+	for _, f := range serverFeatures {
+		if containsString(clientFeatures, f) {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+func containsString(list []string, target string) bool {
+	for _, s := range list {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FeaturePing Client 會回覆 Server 的 PI，用來量測來回延遲
+const FeaturePing = "ping"
+
+// PingInterval Server 傳送 PI 的間隔
+const PingInterval = 2 * time.Second
+
+// maxPendingPings 最多保留幾個尚未收到 PO 的 PI，更早的 PI 收到 PO 時不列入計算
+const maxPendingPings = 8
+
+// latency 以 PI/PO 量測的來回延遲(RTT)與抖動(jitter)
+// 大廳、房間與連線 goroutine 都會讀取，需透過 mutex 存取
+type latency struct {
+	mutex   sync.Mutex
+	rtt     time.Duration // 平滑後的 RTT
+	jitter  time.Duration // RTT 變化量的平均
+	last    time.Duration // 最近一次量測的 RTT
+	samples int
+	pending map[int]time.Time // 已送出、尚未收到 PO 的 PI 序號與送出時間
+}
+
+// sent 記錄 PI 的送出時間，RTT 以 Server 自己的時間計算，不採用 Client 回傳的時間
+func (l *latency) sent(seq int, at time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.pending == nil {
+		l.pending = make(map[int]time.Time)
+	}
+	l.pending[seq] = at
+	for pendingSeq := range l.pending {
+		if pendingSeq <= seq-maxPendingPings {
+			delete(l.pending, pendingSeq)
+		}
+	}
+}
+
+// acked 取出 PI 的送出時間，沒有送出過、已回覆過或已過期的序號回傳 false
+func (l *latency) acked(seq int) (time.Time, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	at, ok := l.pending[seq]
+	delete(l.pending, seq)
+	return at, ok
+}
+
+// record 加入一次量測結果，RTT 與 jitter 的平滑方式與 TCP SRTT、RFC 3550 相同
+func (l *latency) record(sample time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.samples == 0 {
+		l.rtt = sample
+	} else {
+		l.rtt += (sample - l.rtt) / 8
+		l.jitter += (absDuration(sample-l.last) - l.jitter) / 16
+	}
+	l.last = sample
+	l.samples++
+}
+
+func (l *latency) get() (rtt time.Duration, jitter time.Duration, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rtt, l.jitter, l.samples > 0
+}
+
+// Latency 玩家的來回延遲與抖動，還沒有量測結果時 ok 為 false
+func (p *Player) Latency() (rtt time.Duration, jitter time.Duration, ok bool) {
+	return p.latency.get()
+}
+
+// latencyMs 傳送給 Client 的延遲(毫秒)，還沒有量測結果時為 -1
+func (p *Player) latencyMs() (int, int) {
+	rtt, jitter, ok := p.Latency()
+	if !ok {
+		return -1, -1
+	}
+	return int(rtt / time.Millisecond), int(jitter / time.Millisecond)
+}
+
+// sendPing 傳送 PI 給支援的 Client，不記錄在 Log 中
+func (s *Server) sendPing(player *Player) {
+	if !player.hasFeature(FeaturePing) {
+		return
+	}
+	player.pingSeq++
+	player.latency.sent(player.pingSeq, time.Now())
+	player.Session().Send(generatePingPayload(player.pingSeq))
+}
+
+// handlePongPayload 以 PO 的序號找出對應 PI 的送出時間計算 RTT
+func (s *Server) handlePongPayload(msg Message, player *Player) bool {
+	pong, ok := msg.(*PongMsg)
+	if !ok {
+		return false
+	}
+
+	sentAt, ok := player.latency.acked(pong.Seq)
+	if !ok {
+		//沒有送出過的序號(e.g. Client 沒有原樣回傳)或重複回覆不列入計算
+		s.logger.Warn(fmt.Sprintf(logger.InvalidPongMsg, player.Id, pong.Seq))
+		return true
+	}
+	player.latency.record(time.Since(sentAt))
+	return true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyPendingPings(t *testing.T) {
+	var l latency
+	start := time.Now()
+	for seq := 1; seq <= maxPendingPings+2; seq++ {
+		l.sent(seq, start.Add(time.Duration(seq)*PingInterval))
+	}
+
+	cases := []struct {
+		name string
+		seq  int
+		ok   bool
+	}{
+		{"expired", 2, false},
+		{"oldest kept", 3, true},
+		{"latest", maxPendingPings + 2, true},
+		{"already acked", maxPendingPings + 2, false},
+		{"never sent", maxPendingPings + 3, false},
+		{"negative", -1, false},
+	}
+	for _, c := range cases {
+		at, ok := l.acked(c.seq)
+		if ok != c.ok {
+			t.Errorf("%s: acked(%d) ok = %v, want %v", c.name, c.seq, ok, c.ok)
+		}
+		if ok && !at.Equal(start.Add(time.Duration(c.seq)*PingInterval)) {
+			t.Errorf("%s: acked(%d) = %v, want the time it was sent", c.name, c.seq, at)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// PayloadTerminator 舊版封包的結尾符號
+const PayloadTerminator = "~"
+
+// LegacyProtocolVersion 舊版 Client 不會握手，以此版本號代表
+const LegacyProtocolVersion = 0
+
+// legacyCodec 舊版 Unity Client 使用的格式: Header + 以 "," 與 "&" 分隔的內容 + "~"
+// 輸出需與舊版 Server 逐字節相同
+type legacyCodec struct{}
+
+func (legacyCodec) Name() string { return CodecLegacy }
+
+func (legacyCodec) ReadMessage(r *bufio.Reader) (Message, error) {
+	payload, err := r.ReadString('~')
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 3 {
+		return nil, &DecodeError{Err: fmt.Errorf("%w: %q", ErrMalformedBody, payload)}
+	}
+
+	msgType, _ := parseMsgType(payload[0:2])
+	content := removeHeaderTerminator(payload)
+
+	switch msgType {
+	case HeartBeatHeader:
+		return &HeartBeatMsg{}, nil
+	case PlayerNameSetting:
+		return &PlayerNameMsg{Name: content}, nil
+	case LeaveLobby:
+		return &LeaveLobbyMsg{}, nil
+	case CreateRoomHeader:
+		return &CreateRoomMsg{RoomName: content}, nil
+	case EnterRoomHeader:
+		return &EnterRoomMsg{RoomId: content}, nil
+	case LeaveRoomHeader:
+		return &LeaveRoomMsg{RoomId: content}, nil
+	case ReadyStartHeader:
+		return &ReadyStartMsg{RoomId: content}, nil
+	case BattleActionHeader:
+		return &BattleActionMsg{Action: content}, nil
+	case GiveUpBattleHeader:
+		roomId, playerId := parseInterruptBattle(content)
+		return &GiveUpBattleMsg{RoomId: roomId, PlayerId: playerId}, nil
+	}
+	return nil, &DecodeError{MsgType: msgType, Err: ErrUnknownMsgType}
+}
+
+func (legacyCodec) EncodeMessage(msg Message) ([]byte, error) {
+	var payload string
+
+	switch m := msg.(type) {
+	case *RoomListMsg:
+		for i, ri := range m.Rooms {
+			payload += fmt.Sprintf("%s,%s,%s,%d,%d", ri.RoomId, ri.RoomName, ri.CreateDate, ri.PlayerCount, ri.RoomStatus)
+
+			if i != len(m.Rooms)-1 {
+				payload += "&"
+			}
+		}
+	case *RoomDetailMsg:
+		payload = legacyRoomDetail(m)
+	case *RoomFullMsg:
+		payload = m.RoomId
+	case *ConnBrokenMsg:
+		payload = m.PlayerId
+	case *StartBattleMsg:
+		payload = m.RoomId
+	case *BattleSituationMsg:
+		if len(m.Paddles) != 2 {
+			return nil, ErrNotSupportedByCodec
+		}
+		player1, player2 := m.Paddles[0], m.Paddles[1]
+		payload = fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d", m.Ball.X, m.Ball.Y,
+			player1.X, player1.Y, player1.Score, player2.X, player2.Y, player2.Score)
+	case *GiveUpBattleMsg:
+		payload = fmt.Sprintf("%s,%s", m.RoomId, m.PlayerId)
+	case *GiveUpByMyselfMsg:
+		payload = m.RoomId
+	case *OnlinePlayerCountMsg:
+		payload = fmt.Sprintf("%d", m.Count)
+	case *LeaveLobbyMsg:
+		payload = ""
+	case *BattleOverMsg:
+		payload = m.RoomId
+	default:
+		return nil, ErrNotSupportedByCodec
+	}
+
+	return []byte(msg.Type().String() + payload + PayloadTerminator), nil
+}
+
+func legacyRoomDetail(m *RoomDetailMsg) string {
+	if len(m.Players) == 0 {
+		return fmt.Sprintf("%s,%s,,,,,,", m.RoomId, m.RoomName)
+	} else if len(m.Players) == 1 {
+		player1 := m.Players[0]
+		return fmt.Sprintf("%s,%s,%s,%s,%d,,,", m.RoomId, m.RoomName, player1.PlayerId, player1.NickName, player1.ReadyStatus)
+	}
+
+	player1 := m.Players[0]
+	player2 := m.Players[1]
+
+	return fmt.Sprintf("%s,%s,%s,%s,%d,%s,%s,%d", m.RoomId, m.RoomName,
+		player1.PlayerId,
+		player1.NickName,
+		player1.ReadyStatus,
+		player2.PlayerId,
+		player2.NickName,
+		player2.ReadyStatus)
+}
+
+func parseInterruptBattle(payload string) (string, string) {
+	split := strings.SplitN(payload, ",", 2)
+	roomId := split[0]
+	if len(split) < 2 {
+		return roomId, ""
+	}
+	return roomId, split[1]
+}
+
+func removeHeaderTerminator(payload string) string {
+	return payload[2 : len(payload)-1]
+}
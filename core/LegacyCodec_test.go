@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 預期的字串與舊版 Server 的 generateXXXPayload 輸出逐字節相同
+func TestLegacyEncodeGolden(t *testing.T) {
+	player1 := RoomPlayerInfo{PlayerId: "1.2.3.4:5", NickName: "alice", ReadyStatus: 1, PingMs: 30, Rating: 1200}
+	player2 := RoomPlayerInfo{PlayerId: "6.7.8.9:10", NickName: "bob", ReadyStatus: 0, PingMs: -1, Rating: 1000}
+
+	cases := []struct {
+		name string
+		msg  Message
+		want string
+	}{
+		{"RL empty", &RoomListMsg{}, "RL~"},
+		{"RL", &RoomListMsg{Rooms: []RoomInfo{
+			{RoomId: "r1", RoomName: "room1", CreateDate: "2022-06-01 12:00:00", PlayerCount: 1, RoomStatus: RoomStatusWaiting, SpectatorCount: 2},
+			{RoomId: "r2", RoomName: "room2", CreateDate: "2022-06-01 12:30:00", PlayerCount: 2, RoomStatus: RoomStatusPlaying},
+		}}, "RLr1,room1,2022-06-01 12:00:00,1,0&r2,room2,2022-06-01 12:30:00,2,1~"},
+		{"RD without players", &RoomDetailMsg{RoomId: "r1", RoomName: "room1"}, "RDr1,room1,,,,,,~"},
+		{"RD with one player", &RoomDetailMsg{RoomId: "r1", RoomName: "room1", Players: []RoomPlayerInfo{player1}},
+			"RDr1,room1,1.2.3.4:5,alice,1,,,~"},
+		{"RD with two players", &RoomDetailMsg{RoomId: "r1", RoomName: "room1", Players: []RoomPlayerInfo{player1, player2}},
+			"RDr1,room1,1.2.3.4:5,alice,1,6.7.8.9:10,bob,0~"},
+		{"BS", &BattleSituationMsg{
+			Ball: BallState{X: 400, Y: 300},
+			Paddles: []PaddleState{
+				{PlayerId: "p1", X: 0, Y: 225, Score: 3, LastInputSeq: 7, PingMs: 30},
+				{PlayerId: "p2", X: 780, Y: 100, Score: 11},
+			},
+			Tick:         42,
+			ServerTimeMs: 1654084800000,
+		}, "BS400,300,0,225,3,780,100,11~"},
+		{"GB", &GiveUpBattleMsg{RoomId: "r1", PlayerId: "1.2.3.4:5"}, "GBr1,1.2.3.4:5~"},
+		{"OC", &OnlinePlayerCountMsg{Count: 17}, "OC17~"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := legacyCodec{}.EncodeMessage(c.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("EncodeMessage = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLegacyReadGolden(t *testing.T) {
+	cases := []struct {
+		payload string
+		want    Message
+	}{
+		{"HB~", &HeartBeatMsg{}},
+		{"PNalice~", &PlayerNameMsg{Name: "alice"}},
+		{"CRroom1~", &CreateRoomMsg{RoomName: "room1"}},
+		{"ERr1~", &EnterRoomMsg{RoomId: "r1"}},
+		{"RSr1~", &ReadyStartMsg{RoomId: "r1"}},
+		{"BAU~", &BattleActionMsg{Action: ActionUp}},
+		{"GBr1,1.2.3.4:5~", &GiveUpBattleMsg{RoomId: "r1", PlayerId: "1.2.3.4:5"}},
+	}
+
+	//所有封包在同一個串流中，以 "~" 分隔
+	var stream strings.Builder
+	for _, c := range cases {
+		stream.WriteString(c.payload)
+	}
+	r := bufio.NewReader(strings.NewReader(stream.String()))
+	for _, c := range cases {
+		got, err := legacyCodec{}.ReadMessage(r)
+		if err != nil {
+			t.Fatalf("ReadMessage(%q): %v", c.payload, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ReadMessage(%q) = %+v, want %+v", c.payload, got, c.want)
+		}
+	}
+}
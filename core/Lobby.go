@@ -0,0 +1,331 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"time"
+)
+
+const lobbyInboxSize = 64
+
+// HeartbeatTimeout 預設超過此時間沒有收到玩家任何訊息(包含心跳封包)，判定該玩家已經斷線
+const HeartbeatTimeout = 15 * time.Second
+
+// OnlineCountInterval 通知大廳在線人數的間隔
+const OnlineCountInterval = 2000 * time.Millisecond
+
+// 送到大廳 inbox 的事件，玩家清單與房間清單只由大廳 goroutine 修改
+// 需要回覆的事件使用有緩衝的 reply，大廳不會因為回覆而阻塞
+
+// admitPlayer 詢問是否還能接受新玩家，回覆握手拒絕代碼(0 代表接受)
+type admitPlayer struct {
+	reply chan int
+}
+
+// addPlayer 完成握手的玩家進入大廳
+type addPlayer struct {
+	player *Player
+}
+
+// leaveLobby 玩家離開大廳並關閉連線
+type leaveLobby struct {
+	player *Player
+}
+
+// findPlayer 以 SessionToken 找出玩家，不存在時回覆 nil
+type findPlayer struct {
+	token string
+	reply chan *Player
+}
+
+// createRoom 玩家創建房間，房間數量已達上限時回覆 nil
+type createRoom struct {
+	player *Player
+	name   string
+	reply  chan *Room
+}
+
+// findRoom 以房間 id 找出房間，不存在時回覆 nil
+type findRoom struct {
+	roomId string
+	reply  chan *Room
+}
+
+// roomUpdated 房間人數或狀態改變，由房間 goroutine 送出
+type roomUpdated struct {
+	info RoomInfo
+}
+
+// roomClosed 房間已沒有玩家，由房間 goroutine 結束前送出
+type roomClosed struct {
+	roomId string
+}
+
+// broadcastAll 通知所有玩家，完成後關閉 done
+type broadcastAll struct {
+	msg  Message
+	done chan struct{}
+}
+
+// closeAll 關閉所有玩家的連線，完成後關閉 done
+type closeAll struct {
+	done chan struct{}
+}
+
+// runLobby 大廳 goroutine，處理大廳事件、在線人數通知與延遲量測，直到伺服器停止
+func (s *Server) runLobby() {
+	onlineCount := time.NewTicker(OnlineCountInterval)
+	defer onlineCount.Stop()
+	queueReport := time.NewTicker(QueueReportInterval)
+	defer queueReport.Stop()
+	ping := time.NewTicker(PingInterval)
+	defer ping.Stop()
+	quickMatch := time.NewTicker(QuickMatchInterval)
+	defer quickMatch.Stop()
+
+	for {
+		select {
+		case <-s.jobCtx.Done():
+			return
+
+		case event := <-s.lobbyInbox:
+			s.handleLobbyEvent(event)
+
+		case <-onlineCount.C:
+			// 在大廳才傳送
+			s.notifyLobbyPlayer(generateOnlinePlayerCountPayload(len(s.lobbyPlayer)))
+
+		case <-queueReport.C:
+			s.reportPlayerStats()
+
+		case <-ping.C:
+			//等待重連的玩家連線已關閉，重連後才繼續量測
+			for _, player := range s.lobbyPlayer {
+				if !player.isDisconnected() {
+					s.sendPing(player)
+				}
+			}
+
+		case <-quickMatch.C:
+			//房間數量已達上限時等待有房間關閉，並更新排隊玩家的預估時間
+			s.matchPlayers()
+			s.notifyQueueStatus()
+		}
+	}
+}
+
+func (s *Server) handleLobbyEvent(event interface{}) {
+	switch e := event.(type) {
+
+	case admitPlayer:
+		switch {
+		case s.isShuttingDown():
+			e.reply <- RejectShuttingDown
+		case len(s.lobbyRoom) >= s.maxRooms:
+			e.reply <- RejectServerFull
+		default:
+			e.reply <- 0
+		}
+
+	case addPlayer:
+		player := e.player
+		s.lobbyPlayer[player.Id] = player
+		s.logger.Info(fmt.Sprintf("Player %s (ip:%s) 進入大廳", player.Id, player.Session().RemoteAddr()))
+
+		//傳送遊戲大廳給此新玩家
+		s.sendMsg(player, generateRoomsListPayload(s.getRoomList()))
+		//通知所有玩家 有新玩家家加入
+		s.notifyLobbyPlayer(generateOnlinePlayerCountPayload(len(s.lobbyPlayer)))
+
+	case leaveLobby:
+		playerId := e.player.Id
+
+		//通知玩家已成功離開大廳
+		s.sendMsg(e.player, generateLeaveLobbySuccessPayload())
+
+		//關閉連線
+		e.player.Session().Close()
+		delete(s.lobbyPlayer, playerId)
+		s.matchmaker.remove(playerId)
+
+		s.logger.Info(fmt.Sprintf("%s 離開大廳！", playerId))
+		s.logger.Info(fmt.Sprintf("當下人數：%d", len(s.lobbyPlayer)))
+
+		s.notifyLobbyPlayerUpdateRoomList()
+
+	case *ConnBrokenMsg:
+		player := s.lobbyPlayer[e.PlayerId]
+		//已經離開大廳(e.g. LL)的玩家，或是已換成新連線的舊連線通知，不需再處理
+		if player == nil || player.Session() != e.session {
+			break
+		}
+
+		//關閉連線
+		player.Session().Close()
+		delete(s.lobbyPlayer, e.PlayerId)
+		s.matchmaker.remove(e.PlayerId)
+		s.logger.Error(fmt.Sprintf("玩家 %s 連線異常，中止連線", e.PlayerId))
+
+		//處理完後，通知所有玩家，更新大廳與房間資訊
+		s.notifyLobbyPlayerUpdateRoomList()
+		s.logger.Info(logger.NotifyLobbyConnBrokenMsg)
+
+	case findPlayer:
+		var found *Player
+		for _, player := range s.lobbyPlayer {
+			if player.SessionToken == e.token {
+				found = player
+				break
+			}
+		}
+		e.reply <- found
+
+	case createRoom:
+		if len(s.lobbyRoom) >= s.maxRooms {
+			e.reply <- nil
+			break
+		}
+
+		room := newRoom(s, s.generateRoomId(), e.name, e.player)
+		s.lobbyRoom = append(s.lobbyRoom, room)
+		s.roomInfo[room.RoomId] = room.info()
+		go room.run()
+		e.reply <- room
+
+		s.logger.Info(fmt.Sprintf("%s 創建新房間！", e.player.Id))
+
+		//通知所有『在大廳』的玩家
+		s.notifyLobbyPlayerUpdateRoomList()
+
+	case findRoom:
+		e.reply <- s.findRoomById(e.roomId)
+
+	case bindAccount:
+		s.handleBindAccount(e)
+
+	case joinQueue:
+		s.handleJoinQueue(e.player)
+
+	case leaveQueue:
+		s.handleLeaveQueue(e.player)
+
+	case roomUpdated:
+		//房間已移除後才送達的狀態不需再處理
+		if s.findRoomById(e.info.RoomId) == nil {
+			break
+		}
+		s.roomInfo[e.info.RoomId] = e.info
+		s.notifyLobbyPlayerUpdateRoomList()
+
+	case roomClosed:
+		if s.findRoomById(e.roomId) == nil {
+			break
+		}
+		s.lobbyRoom = removeRoom(s.lobbyRoom, e.roomId)
+		delete(s.roomInfo, e.roomId)
+		s.notifyLobbyPlayerUpdateRoomList()
+
+	case broadcastAll:
+		for _, player := range s.lobbyPlayer {
+			s.sendMsg(player, e.msg)
+		}
+		close(e.done)
+
+	case closeAll:
+		for _, player := range s.lobbyPlayer {
+			player.Session().Close()
+		}
+		close(e.done)
+	}
+}
+
+// reportPlayerStats 記錄各玩家的延遲與送出佇列深度，用來觀察哪些 Client 跟不上
+func (s *Server) reportPlayerStats() {
+	for _, player := range s.lobbyPlayer {
+		depth, peak := queueDepth(player.Session())
+		switch {
+		case peak*2 >= s.sendQueueSize:
+			//堆積超過一半，可能即將被中止連線
+			s.logger.Warn(fmt.Sprintf(logger.SendQueueDepthMsg, player.Id, depth, peak))
+		case peak > 0:
+			s.logger.Debug(fmt.Sprintf(logger.SendQueueDepthMsg, player.Id, depth, peak))
+		}
+
+		if rtt, jitter, ok := player.Latency(); ok {
+			s.logger.Info(fmt.Sprintf(logger.PlayerLatencyMsg, player.Id, rtt.Round(time.Millisecond), jitter.Round(time.Millisecond)))
+		}
+	}
+}
+
+// findRoomById 找不到時回傳 nil
+func (s *Server) findRoomById(id string) *Room {
+	for _, room := range s.lobbyRoom {
+		if room.RoomId == id {
+			return room
+		}
+	}
+	return nil
+}
+
+// toLobby 送出事件給大廳 goroutine，伺服器已停止時回傳 false
+func (s *Server) toLobby(event interface{}) bool {
+	select {
+	case s.lobbyInbox <- event:
+		return true
+	case <-s.jobCtx.Done():
+		return false
+	}
+}
+
+// admitPlayer 回傳握手拒絕代碼，0 代表可以接受新玩家
+func (s *Server) admitPlayer() int {
+	reply := make(chan int, 1)
+	if !s.toLobby(admitPlayer{reply: reply}) {
+		return RejectShuttingDown
+	}
+	select {
+	case code := <-reply:
+		return code
+	case <-s.jobCtx.Done():
+		return RejectShuttingDown
+	}
+}
+
+func (s *Server) findPlayer(token string) *Player {
+	reply := make(chan *Player, 1)
+	if !s.toLobby(findPlayer{token: token, reply: reply}) {
+		return nil
+	}
+	select {
+	case result := <-reply:
+		return result
+	case <-s.jobCtx.Done():
+		return nil
+	}
+}
+
+func (s *Server) createRoom(player *Player, name string) *Room {
+	reply := make(chan *Room, 1)
+	if !s.toLobby(createRoom{player: player, name: name, reply: reply}) {
+		return nil
+	}
+	select {
+	case result := <-reply:
+		return result
+	case <-s.jobCtx.Done():
+		return nil
+	}
+}
+
+func (s *Server) findRoom(roomId string) *Room {
+	reply := make(chan *Room, 1)
+	if !s.toLobby(findRoom{roomId: roomId, reply: reply}) {
+		return nil
+	}
+	select {
+	case result := <-reply:
+		return result
+	case <-s.jobCtx.Done():
+		return nil
+	}
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"Pong/logger"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 比賽結束的原因
+const MatchEndScore = "score"           // 先得到結束分數
+const MatchEndSurrender = "surrender"   // 對手投降
+const MatchEndDisconnect = "disconnect" // 對手斷線且無法重連(舊版Client)
+const MatchEndTimeout = "timeout"       // 對手斷線後未在時限內重連
+const MatchEndAborted = "aborted"       // 伺服器關閉時未在期限內結束，沒有勝負
+
+// Client 查詢比賽紀錄的筆數
+const DefaultHistoryCount = 10
+const MaxHistoryCount = 50
+
+var matchBucket = []byte("matches")             // 比賽編號(8 bytes) -> MatchRecord(JSON)
+var playerMatchBucket = []byte("playerMatches") // 玩家 key + 0x00 + 比賽編號 -> 空值，依玩家查詢用的索引
+
+// MatchRecord 一場分出勝負或被中止的比賽，中止的比賽沒有 WinnerId
+type MatchRecord struct {
+	Id        uint64        `json:"id"`
+	RoomId    string        `json:"roomId"`
+	RoomName  string        `json:"roomName"`
+	Players   []MatchPlayer `json:"players"` // 左邊、右邊的玩家
+	WinnerId  string        `json:"winnerId"`
+	EndReason string        `json:"endReason"`
+	StartedAt time.Time     `json:"startedAt"`
+	EndedAt   time.Time     `json:"endedAt"`
+}
+
+// MatchPlayer 比賽中的玩家與最後的分數
+type MatchPlayer struct {
+	PlayerId string `json:"playerId"`
+	Username string `json:"username,omitempty"` // 未登入的玩家為空
+	NickName string `json:"nickName"`
+	Score    int    `json:"score"`
+	Bot      bool   `json:"bot,omitempty"`
+}
+
+// Duration 比賽進行的時間(包含等待斷線玩家重連的時間)
+func (m *MatchRecord) Duration() time.Duration {
+	return m.EndedAt.Sub(m.StartedAt)
+}
+
+// MatchQuery 查詢比賽紀錄的條件，未設定的條件不限制
+type MatchQuery struct {
+	Username string    // 登入玩家的帳號名稱
+	PlayerId string    // 未登入玩家的 id，Username 不為空時忽略
+	From     time.Time // 結束時間不早於 From
+	To       time.Time // 結束時間早於 To
+	Limit    int       // 最多回傳的筆數，0 為不限制
+}
+
+// SaveMatch 保存比賽紀錄並建立每位玩家的索引，編號依保存順序遞增
+func (s *Store) SaveMatch(record *MatchRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		matches := tx.Bucket(matchBucket)
+		index := tx.Bucket(playerMatchBucket)
+
+		id, err := matches.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.Id = id
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		key := matchKey(id)
+		if err := matches.Put(key, data); err != nil {
+			return err
+		}
+		for _, player := range record.Players {
+			if player.Bot {
+				continue
+			}
+			playerKey := append(matchPrefix(player.Username, player.PlayerId), key...)
+			if err := index.Put(playerKey, []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Matches 依條件查詢比賽紀錄，由新到舊排列
+func (s *Store) Matches(q MatchQuery) ([]*MatchRecord, error) {
+	var records []*MatchRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		matches := tx.Bucket(matchBucket)
+
+		//指定玩家時走索引，否則依編號掃描全部比賽
+		var cursor *bolt.Cursor
+		var prefix []byte
+		if q.Username != "" || q.PlayerId != "" {
+			cursor = tx.Bucket(playerMatchBucket).Cursor()
+			prefix = matchPrefix(q.Username, q.PlayerId)
+		} else {
+			cursor = matches.Cursor()
+		}
+
+		for k := seekLast(cursor, prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Prev() {
+			data := matches.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			record := &MatchRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+
+			if !q.To.IsZero() && !record.EndedAt.Before(q.To) {
+				continue
+			}
+			//編號依結束順序遞增，之後的紀錄都更早
+			if !q.From.IsZero() && record.EndedAt.Before(q.From) {
+				break
+			}
+			records = append(records, record)
+			if q.Limit > 0 && len(records) >= q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func matchKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// matchPrefix 玩家索引的 key 前綴，登入的玩家以帳號名稱、未登入的玩家以 '#' + id 區分(帳號名稱不含 '#')
+func matchPrefix(username string, playerId string) []byte {
+	var key []byte
+	if username != "" {
+		key = accountKey(username)
+	} else {
+		key = []byte("#" + playerId)
+	}
+	return append(key, 0)
+}
+
+// seekLast 移動到前綴為 prefix 的最後一個 key，prefix 為空時移動到最後一個 key
+func seekLast(cursor *bolt.Cursor, prefix []byte) []byte {
+	if len(prefix) == 0 {
+		k, _ := cursor.Last()
+		return k
+	}
+
+	//prefix 以 0x00 結尾，下一個可能的前綴是最後一個 byte 換成 0x01
+	end := append(append([]byte(nil), prefix[:len(prefix)-1]...), 1)
+	if k, _ := cursor.Seek(end); k == nil {
+		k, _ = cursor.Last()
+		return k
+	}
+	k, _ := cursor.Prev()
+	return k
+}
+
+// recordMatch 保存比賽紀錄，由 finishBattle 與 abortBattle 在重設分數前呼叫，中止的比賽 winner 為 nil
+func (r *Room) recordMatch(winner *Player) {
+	store := r.server.store
+	if store == nil {
+		return
+	}
+
+	reason := r.endReason
+	if reason == "" {
+		reason = MatchEndScore
+	}
+	record := &MatchRecord{
+		RoomId:    r.RoomId,
+		RoomName:  r.Name,
+		EndReason: reason,
+		StartedAt: r.battleStart,
+		EndedAt:   time.Now(),
+	}
+	if winner != nil {
+		record.WinnerId = winner.Id
+	}
+	for i, player := range r.players {
+		//投降與斷線判負時記錄判定前的分數
+		score := player.CurrentScore
+		if r.endScores != nil {
+			score = r.endScores[i]
+		}
+		username := ""
+		if player.account != nil {
+			username = player.account.Username
+		}
+		record.Players = append(record.Players, MatchPlayer{
+			PlayerId: player.Id,
+			Username: username,
+			NickName: player.NickName,
+			Score:    score,
+			Bot:      player.isBot(),
+		})
+	}
+
+	if err := store.SaveMatch(record); err != nil {
+		r.server.logger.Error(fmt.Sprintf(logger.StoreFailedMsg, err))
+		return
+	}
+	r.server.logger.Info(fmt.Sprintf(logger.MatchRecordedMsg, record.Id, r.RoomId, reason, record.Duration()))
+}
+
+// sendMatchHistory 回覆玩家最近的比賽紀錄，登入的玩家包含此帳號過去所有連線的比賽
+func (s *Server) sendMatchHistory(player *Player, m *MatchHistoryMsg) {
+	if s.store == nil {
+		s.sendError(player, ErrCodeNoHistory, "match history disabled", m)
+		return
+	}
+
+	count := m.Count
+	if count <= 0 {
+		count = DefaultHistoryCount
+	}
+	if count > MaxHistoryCount {
+		count = MaxHistoryCount
+	}
+
+	query := MatchQuery{PlayerId: player.Id, Limit: count}
+	if player.account != nil {
+		query.Username = player.account.Username
+	}
+	records, err := s.store.Matches(query)
+	if err != nil {
+		s.sendAccountError(player, err, m)
+		return
+	}
+	s.sendMsg(player, generateMatchListPayload(records))
+}
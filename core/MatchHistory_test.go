@@ -0,0 +1,235 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 測試用的比賽紀錄，依結束時間由舊到新保存
+var historyStart = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestStore(t *testing.T) *Store {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "pong.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newHistoryStore(t *testing.T) *Store {
+	store := newTestStore(t)
+
+	matches := []struct {
+		left, right MatchPlayer
+		day         int
+	}{
+		{MatchPlayer{PlayerId: "p1", Username: "alice"}, MatchPlayer{PlayerId: "p2", Username: "bob"}, 0},
+		{MatchPlayer{PlayerId: "p3", Username: "Alice"}, MatchPlayer{PlayerId: "p4"}, 1},
+		{MatchPlayer{PlayerId: "p5", Username: "bob"}, MatchPlayer{PlayerId: "p6", Bot: true}, 2},
+		{MatchPlayer{PlayerId: "p7", Username: "alice"}, MatchPlayer{PlayerId: "p8", Username: "bob"}, 3},
+	}
+	for _, m := range matches {
+		ended := historyStart.AddDate(0, 0, m.day)
+		record := &MatchRecord{
+			Players:   []MatchPlayer{m.left, m.right},
+			WinnerId:  m.left.PlayerId,
+			EndReason: MatchEndScore,
+			StartedAt: ended.Add(-time.Minute),
+			EndedAt:   ended,
+		}
+		if err := store.SaveMatch(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func matchIds(records []*MatchRecord) []uint64 {
+	ids := make([]uint64, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+	return ids
+}
+
+func equalIds(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStoreMatches(t *testing.T) {
+	store := newHistoryStore(t)
+
+	cases := []struct {
+		name  string
+		query MatchQuery
+		want  []uint64 // 由新到舊的比賽編號
+	}{
+		{"all", MatchQuery{}, []uint64{4, 3, 2, 1}},
+		{"limit", MatchQuery{Limit: 2}, []uint64{4, 3}},
+		{"player ignores case", MatchQuery{Username: "ALICE"}, []uint64{4, 2, 1}},
+		{"player with limit", MatchQuery{Username: "bob", Limit: 2}, []uint64{4, 3}},
+		{"anonymous player", MatchQuery{PlayerId: "p4"}, []uint64{2}},
+		{"bots are not indexed", MatchQuery{PlayerId: "p6"}, nil},
+		{"player prefix does not match", MatchQuery{Username: "ali"}, nil},
+		{"from", MatchQuery{From: historyStart.AddDate(0, 0, 2)}, []uint64{4, 3}},
+		{"to is exclusive", MatchQuery{To: historyStart.AddDate(0, 0, 1)}, []uint64{1}},
+		{"date range", MatchQuery{From: historyStart.AddDate(0, 0, 1), To: historyStart.AddDate(0, 0, 3)}, []uint64{3, 2}},
+		{"player and date range", MatchQuery{Username: "bob", From: historyStart, To: historyStart.AddDate(0, 0, 3)}, []uint64{3, 1}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			records, err := store.Matches(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchIds(records); !equalIds(got, c.want) {
+				t.Errorf("matches = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAdminMatches(t *testing.T) {
+	store := newHistoryStore(t)
+	s := NewServer(WithStore(store), WithAdminToken("secret"))
+
+	cases := []struct {
+		name       string
+		url        string
+		auth       string // Authorization header
+		wantStatus int
+		want       []uint64
+	}{
+		{"missing token", "/admin/matches", "", http.StatusUnauthorized, nil},
+		{"wrong token", "/admin/matches", "Bearer guess", http.StatusUnauthorized, nil},
+		{"empty bearer token", "/admin/matches", "Bearer ", http.StatusUnauthorized, nil},
+		{"token without scheme", "/admin/matches", "secret", http.StatusUnauthorized, nil},
+		{"other scheme", "/admin/matches", "Basic secret", http.StatusUnauthorized, nil},
+		{"token with trailing data", "/admin/matches", "Bearer secret2", http.StatusUnauthorized, nil},
+		{"all", "/admin/matches", "Bearer secret", http.StatusOK, []uint64{4, 3, 2, 1}},
+		{"player", "/admin/matches?player=alice", "Bearer secret", http.StatusOK, []uint64{4, 2, 1}},
+		{"date range", "/admin/matches?from=2022-06-02T00:00:00Z&to=2022-06-04T00:00:00Z", "Bearer secret", http.StatusOK, []uint64{3, 2}},
+		{"player and date range", "/admin/matches?player=bob&to=2022-06-04T00:00:00%2B08:00&limit=1", "Bearer secret", http.StatusOK, []uint64{3}},
+		{"bad date", "/admin/matches?from=yesterday", "Bearer secret", http.StatusBadRequest, nil},
+		{"bad limit", "/admin/matches?limit=-1", "Bearer secret", http.StatusBadRequest, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.url, nil)
+			if c.auth != "" {
+				req.Header.Set("Authorization", c.auth)
+			}
+			rec := httptest.NewRecorder()
+			s.handleAdminMatches(rec, req)
+
+			if rec.Code != c.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, c.wantStatus, rec.Body.String())
+			}
+			if c.wantStatus != http.StatusOK {
+				return
+			}
+			var resp adminMatchesResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if got := matchIds(resp.Matches); !equalIds(got, c.want) {
+				t.Errorf("matches = %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	//未設定 token 時不提供管理介面
+	rec := httptest.NewRecorder()
+	NewServer(WithStore(store)).handleAdminMatches(rec, httptest.NewRequest(http.MethodGet, "/admin/matches", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without admin token = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// newBattleRoom 兩位玩家在戰鬥中的房間，不啟動大廳與房間 goroutine，直接呼叫房間的方法
+func newBattleRoom(t *testing.T, s *Server) (*Room, *Player, *Player) {
+	session1, _ := NewSessionPipe("p1")
+	session2, _ := NewSessionPipe("p2")
+	player1 := generatePlayer(session1)
+	player2 := generatePlayer(session2)
+
+	r := newRoom(s, "r1", "room1", player1)
+	r.enter(player2)
+	player1.RoomReadyStatus = 1
+	player2.RoomReadyStatus = 1
+	r.updateRoomStatus(RoomStatusPlaying)
+	r.startGame()
+	t.Cleanup(r.stopBattle)
+	return r, player1, player2
+}
+
+func TestAbortBattle(t *testing.T) {
+	t.Run("aborted", func(t *testing.T) {
+		store := newTestStore(t)
+		s := NewServer(WithStore(store), WithLogger(nopLogger{}))
+		r, player1, player2 := newBattleRoom(t, s)
+		player1.CurrentScore = 3
+		player2.CurrentScore = 1
+
+		r.abortBattle()
+
+		records, err := store.Matches(MatchQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("recorded %d matches, want 1", len(records))
+		}
+		record := records[0]
+		if record.EndReason != MatchEndAborted || record.WinnerId != "" {
+			t.Errorf("record end reason = %q, winner = %q, want %q without winner", record.EndReason, record.WinnerId, MatchEndAborted)
+		}
+		if record.Players[0].Score != 3 || record.Players[1].Score != 1 {
+			t.Errorf("record scores = %d:%d, want 3:1", record.Players[0].Score, record.Players[1].Score)
+		}
+
+		//與正常結束的戰鬥相同，玩家回到房間並重設準備狀態
+		if r.RoomStatus != RoomStatusWaiting || r.loop != nil {
+			t.Errorf("room status = %d, loop running = %v after abort", r.RoomStatus, r.loop != nil)
+		}
+		for _, player := range []*Player{player1, player2} {
+			if player.Scene != SceneRoom || player.RoomReadyStatus != 0 || player.CurrentScore != 0 {
+				t.Errorf("player scene = %s, ready = %d, score = %d after abort, want %s, 0, 0",
+					player.Scene, player.RoomReadyStatus, player.CurrentScore, SceneRoom)
+			}
+		}
+	})
+
+	t.Run("already decided", func(t *testing.T) {
+		store := newTestStore(t)
+		s := NewServer(WithStore(store), WithLogger(nopLogger{}))
+		r, player1, player2 := newBattleRoom(t, s)
+
+		//投降後還沒結算就被中止，照常記錄為投降
+		r.setLoser(player2.Id, MatchEndSurrender)
+		r.abortBattle()
+
+		records, err := store.Matches(MatchQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].EndReason != MatchEndSurrender || records[0].WinnerId != player1.Id {
+			t.Fatalf("records = %+v, want one surrender won by %s", records, player1.Id)
+		}
+	})
+}
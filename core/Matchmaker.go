@@ -0,0 +1,197 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// QuickMatchInterval 重新嘗試配對並通知排隊狀態的間隔
+const QuickMatchInterval = 1000 * time.Millisecond
+
+// QuickMatchDefaultWait 還沒有配對紀錄時預估的等待時間
+const QuickMatchDefaultWait = 15 * time.Second
+
+// QuickMatchRoomName 快速配對產生的房間名稱
+const QuickMatchRoomName = "Quick Match"
+
+// joinQueue 玩家加入快速配對佇列
+type joinQueue struct {
+	player *Player
+}
+
+// leaveQueue 玩家取消快速配對
+type leaveQueue struct {
+	player *Player
+}
+
+// matchTicket 排隊中的玩家
+type matchTicket struct {
+	player *Player
+	since  time.Time
+}
+
+// matchmaker 快速配對佇列，依排隊順序兩兩配對，只由大廳 goroutine 存取
+type matchmaker struct {
+	queue   []*matchTicket
+	avgWait time.Duration // 最近配對成功的玩家平均等待時間
+	matched int           // 已配對成功的玩家數
+}
+
+// startMatching 標記玩家開始排隊，已在排隊中時回傳 false
+func (p *Player) startMatching() bool {
+	return atomic.CompareAndSwapInt32(&p.matching, 0, 1)
+}
+
+func (p *Player) stopMatching() {
+	atomic.StoreInt32(&p.matching, 0)
+}
+
+// isMatching 玩家是否在快速配對佇列中
+func (p *Player) isMatching() bool {
+	return atomic.LoadInt32(&p.matching) == 1
+}
+
+// rejectWhileMatching 排隊中的玩家不能自行創建、進入或觀戰房間，也不能修改玩家資料，需先取消快速配對
+// 配對成功時大廳先將玩家綁定到房間才結束排隊，因此確認不在排隊中後還需檢查是否已被移入房間
+func (s *Server) rejectWhileMatching(player *Player, msg Message) bool {
+	if player.isMatching() {
+		s.sendError(player, ErrCodeInQueue, "in quick match queue", msg)
+		return true
+	}
+	if room := player.currentRoom(); room != nil {
+		//玩家的場景已由房間 goroutine 管理，不在此讀取
+		s.sendError(player, ErrCodeWrongScene, fmt.Sprintf("%s not allowed in room %s", msg.Type(), room.RoomId), msg)
+		return true
+	}
+	return false
+}
+
+// remove 將玩家移出佇列，玩家不在佇列中時回傳 false
+func (m *matchmaker) remove(playerId string) bool {
+	for i, ticket := range m.queue {
+		if ticket.player.Id == playerId {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			ticket.player.stopMatching()
+			return true
+		}
+	}
+	return false
+}
+
+// recordWait 記錄配對成功的玩家等待了多久，用來預估之後的等待時間
+func (m *matchmaker) recordWait(wait time.Duration) {
+	if m.matched == 0 {
+		m.avgWait = wait
+	} else {
+		m.avgWait += (wait - m.avgWait) / 4
+	}
+	m.matched++
+}
+
+// estimate 預估此玩家還需要等待多久
+func (m *matchmaker) estimate(ticket *matchTicket, now time.Time) time.Duration {
+	expected := m.avgWait
+	if m.matched == 0 {
+		expected = QuickMatchDefaultWait
+	}
+	if remaining := expected - now.Sub(ticket.since); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// handleJoinQueue 玩家加入佇列後立即嘗試配對
+func (s *Server) handleJoinQueue(player *Player) {
+	//已離開大廳或斷線的玩家
+	if s.lobbyPlayer[player.Id] == nil {
+		player.stopMatching()
+		return
+	}
+	if s.isShuttingDown() {
+		player.stopMatching()
+		s.sendError(player, ErrCodeShuttingDown, "server shutting down", &QuickMatchMsg{})
+		return
+	}
+
+	s.matchmaker.queue = append(s.matchmaker.queue, &matchTicket{player: player, since: time.Now()})
+	s.logger.Info(fmt.Sprintf(logger.QuickMatchJoinMsg, player.Id, len(s.matchmaker.queue)))
+
+	s.matchPlayers()
+	s.notifyQueueStatus()
+}
+
+// handleLeaveQueue 玩家取消快速配對，回覆 QC
+func (s *Server) handleLeaveQueue(player *Player) {
+	if !s.matchmaker.remove(player.Id) {
+		return
+	}
+	s.sendMsg(player, generateQuickMatchCancelPayload())
+	s.logger.Info(fmt.Sprintf(logger.QuickMatchCancelMsg, player.Id))
+
+	s.notifyQueueStatus()
+}
+
+// matchPlayers 配對評分接近的玩家(見 findMatch)，為每一對創建房間並讓雙方直接準備開始
+// 房間數量已達上限或沒有合適的對手時留在佇列中，下次檢查時再配對
+func (s *Server) matchPlayers() {
+	m := &s.matchmaker
+	now := time.Now()
+
+	for len(m.queue) >= 2 && len(s.lobbyRoom) < s.maxRooms && !s.isShuttingDown() {
+		first, second, ok := m.findMatch(now)
+		if !ok {
+			break
+		}
+		m.queue = removeTickets(m.queue, first, second)
+
+		room := newQuickMatchRoom(s, s.generateRoomId(), first.player, second.player)
+		s.lobbyRoom = append(s.lobbyRoom, room)
+		s.roomInfo[room.RoomId] = room.info()
+
+		for _, ticket := range []*matchTicket{first, second} {
+			m.recordWait(now.Sub(ticket.since))
+			ticket.player.stopMatching()
+		}
+		go room.run()
+
+		s.logger.Info(fmt.Sprintf(logger.QuickMatchedMsg, first.player.Id, second.player.Id, room.RoomId))
+
+		//通知所有『在大廳』的玩家
+		s.notifyLobbyPlayerUpdateRoomList()
+	}
+}
+
+// removeTickets 移除配對成功的玩家，保持其餘玩家的排隊順序
+func removeTickets(queue []*matchTicket, first *matchTicket, second *matchTicket) []*matchTicket {
+	remaining := queue[:0]
+	for _, ticket := range queue {
+		if ticket != first && ticket != second {
+			remaining = append(remaining, ticket)
+		}
+	}
+	return remaining
+}
+
+// notifyQueueStatus 通知排隊中的玩家目前的順位與預估等待時間
+func (s *Server) notifyQueueStatus() {
+	now := time.Now()
+	for i, ticket := range s.matchmaker.queue {
+		s.sendMsg(ticket.player, generateQueueStatusPayload(i+1, len(s.matchmaker.queue), s.matchmaker.estimate(ticket, now)))
+	}
+}
+
+// newQuickMatchRoom 產生已有兩位玩家的房間，雙方都是準備狀態，房間開始後直接倒數
+// 玩家綁定房間後，其連線 goroutine 就會把訊息交給房間，因此玩家的狀態需在綁定前設定好
+func newQuickMatchRoom(server *Server, roomId string, player1 *Player, player2 *Player) *Room {
+	for _, player := range []*Player{player1, player2} {
+		player.SetScene(SceneRoom)
+		player.RoomReadyStatus = 1
+	}
+
+	r := newRoom(server, roomId, QuickMatchRoomName, player1)
+	r.players = append(r.players, player2)
+	player2.setRoom(r)
+	return r
+}
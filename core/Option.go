@@ -0,0 +1,119 @@
+package core
+
+import "time"
+
+// Logger Server 輸出 Log 的介面，預設使用 logger.Log
+type Logger interface {
+	Debug(message string)
+	Info(message string)
+	Warn(message string)
+	Error(message string)
+}
+
+// Option 設定 NewServer 產生的 Server
+type Option func(*Server)
+
+// WithListenAddr ListenAndServe 監聽的 TCP 位址(e.g. "127.0.0.1:4321")
+func WithListenAddr(addr string) Option {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithWebSocketAddr ListenAndServe 同時在此位址接受 WebSocket 連線
+func WithWebSocketAddr(addr string) Option {
+	return func(s *Server) {
+		s.wsAddr = addr
+	}
+}
+
+// WithTickInterval 每一步模擬的間隔(e.g. time.Second/120)
+func WithTickInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.tickInterval = interval
+	}
+}
+
+// WithSendInterval 傳送戰鬥狀態給玩家的間隔(e.g. time.Second/30)，可與模擬間隔不同
+func WithSendInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.sendInterval = interval
+	}
+}
+
+// WithMaxRooms 房間數量上限，達到上限後拒絕新玩家與新房間
+func WithMaxRooms(maxRooms int) Option {
+	return func(s *Server) {
+		s.maxRooms = maxRooms
+	}
+}
+
+// WithFinalScore 遊戲結束分數
+func WithFinalScore(score int) Option {
+	return func(s *Server) {
+		s.finalScore = score
+	}
+}
+
+// WithPhysics 所有房間預設的球速與反彈設定
+func WithPhysics(physics Physics) Option {
+	return func(s *Server) {
+		s.physics = physics
+	}
+}
+
+// WithRoomPhysics 依房間名稱決定各房間的球速與反彈設定(e.g. 練習房使用較慢的球速)
+func WithRoomPhysics(fn func(roomName string) Physics) Option {
+	return func(s *Server) {
+		s.roomPhysics = fn
+	}
+}
+
+// WithStore 使用此資料庫保存帳號、評分與戰績，由呼叫端負責關閉
+func WithStore(store *Store) Option {
+	return func(s *Server) {
+		s.store = store
+	}
+}
+
+// WithAdminToken 啟用 WebSocket gateway 上的管理介面(AdminMatchesPath)，請求需帶著此 Bearer token
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithHandshakeTimeout 連線後需在此時間內送出 HI
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.handshakeTimeout = timeout
+	}
+}
+
+// WithHeartbeatTimeout 超過此時間沒有收到玩家任何訊息(包含心跳封包)即判定斷線
+func WithHeartbeatTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.heartbeatTimeout = timeout
+	}
+}
+
+// WithSendQueueSize 每條連線最多暫存的待送訊息數
+func WithSendQueueSize(size int) Option {
+	return func(s *Server) {
+		s.sendQueueSize = size
+	}
+}
+
+// WithSlowClientTimeout 送出佇列持續滿載超過此時間的 Client 會被中止連線
+func WithSlowClientTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.slowClientTimeout = timeout
+	}
+}
+
+// WithLogger 替換 Server 使用的 Log
+func WithLogger(l Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
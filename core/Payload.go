@@ -2,164 +2,1111 @@ package core
 
 import (
 	"fmt"
-	"strings"
+	"math"
+	"time"
 )
 
-const PayloadTerminator = "~"
-
-const ConnBrokenHeader = "CB" // Connection Broken 連線斷線訊息
-const HeartBeatHeader = "HB"  // Connection Broken 連線斷線訊息
-
-const RoomInfoHeader = "RL" // Room列表
-const PlayerNameSetting = "PN"
-const LeaveLobby = "LL"        // Leave Lobby 離開大廳
-const OnlinePlayerCount = "OC" // Leave Lobby 離開大廳
-
-const CreateRoomHeader = "CR" // Create Room 創建房間
-const RoomDetailHeader = "RD" // Room Detail 房間詳細內容
-const RoomFullHeader = "RF"   // Room 房間人數已滿
-const EnterRoomHeader = "ER"  // Enter Room 進入房間
-const LeaveRoomHeader = "LR"  // Leave Room 離開房間
-const ReadyStartHeader = "RS" // Ready Start 準備開始
-
-const StartBattleHeader = "SB"     // Start battle 開始戰鬥
-const BattleSituationHeader = "BS" // Battle status 戰鬥中的狀態
-const BattleActionHeader = "BA"    // Battle operation 戰鬥中玩家的移動操作
-const BattleOverHeader = "BO"      // Battle operation 戰鬥中玩家的操作
-const GiveUpBattleHeader = "GB"    // Give up Battle 中斷戰鬥
-const GiveUpByMyselfHeader = "GM"  // Give up by myself 自行發起投降戰鬥
-
-func generateRoomsListPayload() string {
-	riList := getRoomList()
-	var payload string
-	for i := 0; i < len(riList); i += 1 {
-		ri := riList[i].roomId
-		rn := riList[i].roomName
-		cd := riList[i].createDate
-		pc := riList[i].playerCount
-		rs := riList[i].RoomStatus
-
-		payload += fmt.Sprintf("%s,%s,%s,%d,%d", ri, rn, cd, pc, rs)
-
-		if i != len(riList)-1 {
-			payload += "&"
+// MsgType 封包類型，由兩個 ASCII 字元組成(沿用原本的兩字 Header)
+type MsgType uint16
+
+func (t MsgType) String() string {
+	return string([]byte{byte(t >> 8), byte(t)})
+}
+
+const HelloHeader MsgType = 'H'<<8 | 'I'       // Hello 連線後 Client 的第一個封包(版本與功能)
+const WelcomeHeader MsgType = 'W'<<8 | 'C'     // Welcome 握手成功，回覆協商結果
+const HelloRejectHeader MsgType = 'H'<<8 | 'R' // Hello Rejected 握手失敗
+
+const ErrorHeader MsgType = 'E'<<8 | 'M' // Error Message Client 的操作被拒絕
+
+const ConnBrokenHeader MsgType = 'C'<<8 | 'B'         // Connection Broken 連線斷線訊息
+const ReconnectTimeoutHeader MsgType = 'R'<<8 | 'T'   // Reconnect Timeout 斷線玩家未在時限內重連(僅在 server 內部傳遞)
+const PlayerDisconnectedHeader MsgType = 'P'<<8 | 'D' // Player Disconnected 戰鬥中對手斷線，等待重連
+const PlayerResumedHeader MsgType = 'P'<<8 | 'R'      // Player Resumed 斷線的玩家已重新連線
+const HeartBeatHeader MsgType = 'H'<<8 | 'B'          // Heart Beat 心跳封包
+const ServerShutdownHeader MsgType = 'S'<<8 | 'D'     // Server shutDown 伺服器即將關閉
+const PingHeader MsgType = 'P'<<8 | 'I'               // Ping Server 量測來回延遲
+const PongHeader MsgType = 'P'<<8 | 'O'               // Pong Client 原樣回傳 PI 的內容
+
+const RoomInfoHeader MsgType = 'R'<<8 | 'L' // Room列表
+const PlayerNameSetting MsgType = 'P'<<8 | 'N'
+const LeaveLobby MsgType = 'L'<<8 | 'L'        // Leave Lobby 離開大廳
+const OnlinePlayerCount MsgType = 'O'<<8 | 'C' // Online Count 在線人數
+
+const RegisterHeader MsgType = 'R'<<8 | 'G'    // Register 註冊帳號並登入
+const LoginHeader MsgType = 'L'<<8 | 'I'       // Log In 登入帳號
+const AccountInfoHeader MsgType = 'A'<<8 | 'I' // Account Info 登入成功，帳號的暱稱、評分與戰績
+
+const MatchHistoryHeader MsgType = 'M'<<8 | 'H' // Match History 查詢最近的比賽紀錄
+const MatchListHeader MsgType = 'M'<<8 | 'L'    // Match List 比賽紀錄
+
+const CreateRoomHeader MsgType = 'C'<<8 | 'R' // Create Room 創建房間
+const RoomDetailHeader MsgType = 'R'<<8 | 'D' // Room Detail 房間詳細內容
+const RoomFullHeader MsgType = 'R'<<8 | 'F'   // Room 房間人數已滿
+const EnterRoomHeader MsgType = 'E'<<8 | 'R'  // Enter Room 進入房間
+const LeaveRoomHeader MsgType = 'L'<<8 | 'R'  // Leave Room 離開房間
+const ReadyStartHeader MsgType = 'R'<<8 | 'S' // Ready Start 準備開始
+const WatchRoomHeader MsgType = 'W'<<8 | 'R'  // Watch Room 觀戰
+const AddBotHeader MsgType = 'A'<<8 | 'B'     // Add Bot 加入電腦玩家
+const RemoveBotHeader MsgType = 'K'<<8 | 'B'  // Kick Bot 移除電腦玩家
+
+const QuickMatchHeader MsgType = 'Q'<<8 | 'M'       // Quick Match 加入快速配對
+const QuickMatchCancelHeader MsgType = 'Q'<<8 | 'C' // Quick match Cancel 取消快速配對
+const QueueStatusHeader MsgType = 'Q'<<8 | 'S'      // Queue Status 快速配對的排隊狀態
+
+const StartBattleHeader MsgType = 'S'<<8 | 'B'     // Start battle 開始戰鬥
+const BattleSituationHeader MsgType = 'B'<<8 | 'S' // Battle status 戰鬥中的狀態
+const BattleActionHeader MsgType = 'B'<<8 | 'A'    // Battle operation 戰鬥中玩家的移動操作
+const BattleOverHeader MsgType = 'B'<<8 | 'O'      // Battle over 戰鬥結束
+const GiveUpBattleHeader MsgType = 'G'<<8 | 'B'    // Give up Battle 中斷戰鬥
+const GiveUpByMyselfHeader MsgType = 'G'<<8 | 'M'  // Give up by myself 自行發起投降戰鬥
+
+// Message 所有在連線、大廳與房間 inbox 上傳遞的訊息
+type Message interface {
+	Type() MsgType
+	marshal(w *bodyWriter)
+	unmarshal(r *bodyReader)
+}
+
+// newMessage 依封包類型產生對應的空訊息，未知類型回傳 nil
+func newMessage(t MsgType) Message {
+	switch t {
+	case HelloHeader:
+		return &HelloMsg{}
+	case WelcomeHeader:
+		return &WelcomeMsg{}
+	case HelloRejectHeader:
+		return &HelloRejectMsg{}
+	case ErrorHeader:
+		return &ErrorMsg{}
+	case ConnBrokenHeader:
+		return &ConnBrokenMsg{}
+	case ReconnectTimeoutHeader:
+		return &ReconnectTimeoutMsg{}
+	case PlayerDisconnectedHeader:
+		return &PlayerDisconnectedMsg{}
+	case PlayerResumedHeader:
+		return &PlayerResumedMsg{}
+	case HeartBeatHeader:
+		return &HeartBeatMsg{}
+	case ServerShutdownHeader:
+		return &ServerShutdownMsg{}
+	case PingHeader:
+		return &PingMsg{}
+	case PongHeader:
+		return &PongMsg{}
+	case RoomInfoHeader:
+		return &RoomListMsg{}
+	case PlayerNameSetting:
+		return &PlayerNameMsg{}
+	case LeaveLobby:
+		return &LeaveLobbyMsg{}
+	case OnlinePlayerCount:
+		return &OnlinePlayerCountMsg{}
+	case RegisterHeader:
+		return &RegisterMsg{}
+	case LoginHeader:
+		return &LoginMsg{}
+	case AccountInfoHeader:
+		return &AccountInfoMsg{}
+	case MatchHistoryHeader:
+		return &MatchHistoryMsg{}
+	case MatchListHeader:
+		return &MatchListMsg{}
+	case CreateRoomHeader:
+		return &CreateRoomMsg{}
+	case RoomDetailHeader:
+		return &RoomDetailMsg{}
+	case RoomFullHeader:
+		return &RoomFullMsg{}
+	case EnterRoomHeader:
+		return &EnterRoomMsg{}
+	case LeaveRoomHeader:
+		return &LeaveRoomMsg{}
+	case ReadyStartHeader:
+		return &ReadyStartMsg{}
+	case WatchRoomHeader:
+		return &WatchRoomMsg{}
+	case AddBotHeader:
+		return &AddBotMsg{}
+	case RemoveBotHeader:
+		return &RemoveBotMsg{}
+	case QuickMatchHeader:
+		return &QuickMatchMsg{}
+	case QuickMatchCancelHeader:
+		return &QuickMatchCancelMsg{}
+	case QueueStatusHeader:
+		return &QueueStatusMsg{}
+	case StartBattleHeader:
+		return &StartBattleMsg{}
+	case BattleSituationHeader:
+		return &BattleSituationMsg{}
+	case BattleActionHeader:
+		return &BattleActionMsg{}
+	case BattleOverHeader:
+		return &BattleOverMsg{}
+	case GiveUpBattleHeader:
+		return &GiveUpBattleMsg{}
+	case GiveUpByMyselfHeader:
+		return &GiveUpByMyselfMsg{}
+	}
+	return nil
+}
+
+// HelloMsg Client 支援的協定版本與功能，SessionToken 不為空時代表要恢復斷線前的玩家
+type HelloMsg struct {
+	Version      int      `json:"version"`
+	Features     []string `json:"features"`
+	SessionToken string   `json:"sessionToken"`
+}
+
+func (m *HelloMsg) Type() MsgType { return HelloHeader }
+
+func (m *HelloMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Version)
+	w.putStrings(m.Features)
+	w.putString(m.SessionToken)
+}
+
+func (m *HelloMsg) unmarshal(r *bodyReader) {
+	m.Version = r.int()
+	m.Features = r.strings()
+	m.SessionToken = r.string()
+}
+
+// WelcomeMsg 握手成功，Server 選定的版本、功能與遊戲場地參數
+// 恢復斷線時 Scene 與 RoomId 為斷線前所在的場景與房間
+type WelcomeMsg struct {
+	Version        int      `json:"version"`
+	Features       []string `json:"features"`
+	TickIntervalMs int      `json:"tickIntervalMs"`
+	WindowWidth    int      `json:"windowWidth"`
+	WindowHeight   int      `json:"windowHeight"`
+	PaddleHeight   int      `json:"paddleHeight"`
+	PlayerId       string   `json:"playerId"`
+	SessionToken   string   `json:"sessionToken"`
+	Scene          string   `json:"scene"`
+	RoomId         string   `json:"roomId"`
+}
+
+func (m *WelcomeMsg) Type() MsgType { return WelcomeHeader }
+
+func (m *WelcomeMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Version)
+	w.putStrings(m.Features)
+	w.putInt(m.TickIntervalMs)
+	w.putInt(m.WindowWidth)
+	w.putInt(m.WindowHeight)
+	w.putInt(m.PaddleHeight)
+	w.putString(m.PlayerId)
+	w.putString(m.SessionToken)
+	w.putString(m.Scene)
+	w.putString(m.RoomId)
+}
+
+func (m *WelcomeMsg) unmarshal(r *bodyReader) {
+	m.Version = r.int()
+	m.Features = r.strings()
+	m.TickIntervalMs = r.int()
+	m.WindowWidth = r.int()
+	m.WindowHeight = r.int()
+	m.PaddleHeight = r.int()
+	m.PlayerId = r.string()
+	m.SessionToken = r.string()
+	m.Scene = r.string()
+	m.RoomId = r.string()
+}
+
+// HelloRejectMsg 握手失敗的原因，送出後 Server 會關閉連線
+type HelloRejectMsg struct {
+	Code          int    `json:"code"`
+	Reason        string `json:"reason"`
+	ServerVersion int    `json:"serverVersion"`
+}
+
+func (m *HelloRejectMsg) Type() MsgType { return HelloRejectHeader }
+
+func (m *HelloRejectMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Code)
+	w.putString(m.Reason)
+	w.putInt(m.ServerVersion)
+}
+
+func (m *HelloRejectMsg) unmarshal(r *bodyReader) {
+	m.Code = r.int()
+	m.Reason = r.string()
+	m.ServerVersion = r.int()
+}
+
+// ErrorMsg 的錯誤代碼
+const ErrCodeMalformedPayload = 1 // 封包內容無法解析
+const ErrCodeUnknownMessage = 2   // 不認識的封包類型
+const ErrCodeWrongScene = 3       // 目前場景不允許此操作
+const ErrCodeUnknownRoom = 4      // 房間不存在
+const ErrCodeNotInRoom = 5        // 玩家不在該房間中
+const ErrCodeRoomFull = 6         // 房間人數已滿
+const ErrCodeServerFull = 7       // 房間數量已達上限
+const ErrCodeShuttingDown = 8     // 伺服器關閉中，不再開始新的戰鬥
+const ErrCodeBadDifficulty = 9    // 不認識的電腦玩家難度
+const ErrCodeNotPlaying = 10      // 房間不在戰鬥中，無法觀戰
+const ErrCodeInQueue = 11         // 快速配對排隊中，需先取消才能自行進入房間
+const ErrCodeNoAccounts = 12      // 伺服器未啟用帳號
+const ErrCodeBadAccount = 13      // 帳號、密碼或暱稱格式不正確
+const ErrCodeAccountExists = 14   // 帳號已存在
+const ErrCodeLoginFailed = 15     // 帳號或密碼錯誤
+const ErrCodeNickNameTaken = 16   // 暱稱已被其他帳號使用
+const ErrCodeAlreadyLoggedIn = 17 // 已經登入，或此帳號已在其他連線登入
+const ErrCodeStoreFailed = 18     // 資料庫存取失敗
+const ErrCodeNoHistory = 19       // 伺服器未啟用比賽紀錄
+const ErrCodeBadRoomName = 20     // 房間名稱過長
+
+// ErrorMsg Client 的操作被拒絕，RequestHeader 為造成錯誤的封包類型
+type ErrorMsg struct {
+	Code          int    `json:"code"`
+	Reason        string `json:"reason"`
+	RequestHeader string `json:"requestHeader"`
+}
+
+func (m *ErrorMsg) Type() MsgType { return ErrorHeader }
+
+func (m *ErrorMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Code)
+	w.putString(m.Reason)
+	w.putString(m.RequestHeader)
+}
+
+func (m *ErrorMsg) unmarshal(r *bodyReader) {
+	m.Code = r.int()
+	m.Reason = r.string()
+	m.RequestHeader = r.string()
+}
+
+// ConnBrokenMsg 連線斷線(僅在 server 內部傳遞)
+type ConnBrokenMsg struct {
+	PlayerId string `json:"playerId"`
+
+	// session 斷線的連線，玩家已重新連線時可辨識出這是舊連線的通知
+	session Session
+}
+
+func (m *ConnBrokenMsg) Type() MsgType           { return ConnBrokenHeader }
+func (m *ConnBrokenMsg) marshal(w *bodyWriter)   { w.putString(m.PlayerId) }
+func (m *ConnBrokenMsg) unmarshal(r *bodyReader) { m.PlayerId = r.string() }
+
+// ReconnectTimeoutMsg 斷線玩家未在時限內重連(僅在 server 內部傳遞)
+type ReconnectTimeoutMsg struct {
+	PlayerId string `json:"playerId"`
+}
+
+func (m *ReconnectTimeoutMsg) Type() MsgType           { return ReconnectTimeoutHeader }
+func (m *ReconnectTimeoutMsg) marshal(w *bodyWriter)   { w.putString(m.PlayerId) }
+func (m *ReconnectTimeoutMsg) unmarshal(r *bodyReader) { m.PlayerId = r.string() }
+
+// PlayerDisconnectedMsg 戰鬥中對手斷線，遊戲暫停直到對手重連或逾時判負
+type PlayerDisconnectedMsg struct {
+	PlayerId      string `json:"playerId"`
+	GracePeriodMs int    `json:"gracePeriodMs"`
+}
+
+func (m *PlayerDisconnectedMsg) Type() MsgType { return PlayerDisconnectedHeader }
+
+func (m *PlayerDisconnectedMsg) marshal(w *bodyWriter) {
+	w.putString(m.PlayerId)
+	w.putInt(m.GracePeriodMs)
+}
+
+func (m *PlayerDisconnectedMsg) unmarshal(r *bodyReader) {
+	m.PlayerId = r.string()
+	m.GracePeriodMs = r.int()
+}
+
+// PlayerResumedMsg 斷線的玩家已重新連線，遊戲繼續
+type PlayerResumedMsg struct {
+	PlayerId string `json:"playerId"`
+}
+
+func (m *PlayerResumedMsg) Type() MsgType           { return PlayerResumedHeader }
+func (m *PlayerResumedMsg) marshal(w *bodyWriter)   { w.putString(m.PlayerId) }
+func (m *PlayerResumedMsg) unmarshal(r *bodyReader) { m.PlayerId = r.string() }
+
+// ServerShutdownMsg 伺服器即將關閉，進行中的戰鬥需在 GracePeriodMs 內結束，否則中止
+type ServerShutdownMsg struct {
+	Reason        string `json:"reason"`
+	GracePeriodMs int    `json:"gracePeriodMs"`
+}
+
+func (m *ServerShutdownMsg) Type() MsgType { return ServerShutdownHeader }
+
+func (m *ServerShutdownMsg) marshal(w *bodyWriter) {
+	w.putString(m.Reason)
+	w.putInt(m.GracePeriodMs)
+}
+
+func (m *ServerShutdownMsg) unmarshal(r *bodyReader) {
+	m.Reason = r.string()
+	m.GracePeriodMs = r.int()
+}
+
+// PingMsg Server 傳送的延遲量測，Client 需以 PO 原樣回傳
+type PingMsg struct {
+	Seq          int   `json:"seq"`
+	ServerTimeMs int64 `json:"serverTimeMs"`
+}
+
+func (m *PingMsg) Type() MsgType { return PingHeader }
+
+func (m *PingMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Seq)
+	w.putInt64(m.ServerTimeMs)
+}
+
+func (m *PingMsg) unmarshal(r *bodyReader) {
+	m.Seq = r.int()
+	m.ServerTimeMs = r.int64()
+}
+
+// PongMsg Client 回覆的 PI 內容
+type PongMsg struct {
+	Seq          int   `json:"seq"`
+	ServerTimeMs int64 `json:"serverTimeMs"`
+}
+
+func (m *PongMsg) Type() MsgType { return PongHeader }
+
+func (m *PongMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Seq)
+	w.putInt64(m.ServerTimeMs)
+}
+
+func (m *PongMsg) unmarshal(r *bodyReader) {
+	m.Seq = r.int()
+	m.ServerTimeMs = r.int64()
+}
+
+// HeartBeatMsg Client心跳封包
+type HeartBeatMsg struct{}
+
+func (m *HeartBeatMsg) Type() MsgType           { return HeartBeatHeader }
+func (m *HeartBeatMsg) marshal(w *bodyWriter)   {}
+func (m *HeartBeatMsg) unmarshal(r *bodyReader) {}
+
+// RoomListMsg 大廳房間列表
+type RoomListMsg struct {
+	Rooms []RoomInfo `json:"rooms"`
+}
+
+func (m *RoomListMsg) Type() MsgType { return RoomInfoHeader }
+
+func (m *RoomListMsg) marshal(w *bodyWriter) {
+	w.putCount(len(m.Rooms))
+	for _, ri := range m.Rooms {
+		w.putString(ri.RoomId)
+		w.putString(ri.RoomName)
+		w.putString(ri.CreateDate)
+		w.putInt(ri.PlayerCount)
+		w.putInt(ri.RoomStatus)
+	}
+
+	//以下欄位附加在最後，舊版 Client 會忽略
+	for _, ri := range m.Rooms {
+		w.putInt(ri.SpectatorCount)
+	}
+}
+
+func (m *RoomListMsg) unmarshal(r *bodyReader) {
+	n := r.count()
+	m.Rooms = make([]RoomInfo, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Rooms = append(m.Rooms, RoomInfo{
+			RoomId:      r.string(),
+			RoomName:    r.string(),
+			CreateDate:  r.string(),
+			PlayerCount: r.int(),
+			RoomStatus:  r.int(),
+		})
+	}
+
+	if !r.more() {
+		return
+	}
+	for i := range m.Rooms {
+		m.Rooms[i].SpectatorCount = r.int()
+	}
+}
+
+// PlayerNameMsg 設定玩家名字
+type PlayerNameMsg struct {
+	Name string `json:"name"`
+}
+
+func (m *PlayerNameMsg) Type() MsgType           { return PlayerNameSetting }
+func (m *PlayerNameMsg) marshal(w *bodyWriter)   { w.putString(m.Name) }
+func (m *PlayerNameMsg) unmarshal(r *bodyReader) { m.Name = r.string() }
+
+// LeaveLobbyMsg 離開大廳(Client請求與Server回覆共用)
+type LeaveLobbyMsg struct{}
+
+func (m *LeaveLobbyMsg) Type() MsgType           { return LeaveLobby }
+func (m *LeaveLobbyMsg) marshal(w *bodyWriter)   {}
+func (m *LeaveLobbyMsg) unmarshal(r *bodyReader) {}
+
+// OnlinePlayerCountMsg 在線人數
+type OnlinePlayerCountMsg struct {
+	Count int `json:"count"`
+}
+
+func (m *OnlinePlayerCountMsg) Type() MsgType           { return OnlinePlayerCount }
+func (m *OnlinePlayerCountMsg) marshal(w *bodyWriter)   { w.putInt(m.Count) }
+func (m *OnlinePlayerCountMsg) unmarshal(r *bodyReader) { m.Count = r.int() }
+
+// RegisterMsg 註冊帳號，NickName 留空時使用帳號名稱
+type RegisterMsg struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	NickName string `json:"nickName"`
+}
+
+func (m *RegisterMsg) Type() MsgType { return RegisterHeader }
+
+func (m *RegisterMsg) marshal(w *bodyWriter) {
+	w.putString(m.Username)
+	w.putString(m.Password)
+	w.putString(m.NickName)
+}
+
+func (m *RegisterMsg) unmarshal(r *bodyReader) {
+	m.Username = r.string()
+	m.Password = r.string()
+	m.NickName = r.string()
+}
+
+// LoginMsg 登入帳號
+type LoginMsg struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (m *LoginMsg) Type() MsgType { return LoginHeader }
+
+func (m *LoginMsg) marshal(w *bodyWriter) {
+	w.putString(m.Username)
+	w.putString(m.Password)
+}
+
+func (m *LoginMsg) unmarshal(r *bodyReader) {
+	m.Username = r.string()
+	m.Password = r.string()
+}
+
+// AccountInfoMsg 登入成功，帳號的暱稱、評分與戰績
+type AccountInfoMsg struct {
+	Username string `json:"username"`
+	NickName string `json:"nickName"`
+	Rating   int    `json:"rating"`
+	Wins     int    `json:"wins"`
+	Losses   int    `json:"losses"`
+}
+
+func (m *AccountInfoMsg) Type() MsgType { return AccountInfoHeader }
+
+func (m *AccountInfoMsg) marshal(w *bodyWriter) {
+	w.putString(m.Username)
+	w.putString(m.NickName)
+	w.putInt(m.Rating)
+	w.putInt(m.Wins)
+	w.putInt(m.Losses)
+}
+
+func (m *AccountInfoMsg) unmarshal(r *bodyReader) {
+	m.Username = r.string()
+	m.NickName = r.string()
+	m.Rating = r.int()
+	m.Wins = r.int()
+	m.Losses = r.int()
+}
+
+// MatchHistoryMsg 查詢最近的比賽紀錄，Count 為 0 時使用預設筆數
+type MatchHistoryMsg struct {
+	Count int `json:"count"`
+}
+
+func (m *MatchHistoryMsg) Type() MsgType           { return MatchHistoryHeader }
+func (m *MatchHistoryMsg) marshal(w *bodyWriter)   { w.putInt(m.Count) }
+func (m *MatchHistoryMsg) unmarshal(r *bodyReader) { m.Count = r.int() }
+
+// MatchListMsg 比賽紀錄，由新到舊排列
+type MatchListMsg struct {
+	Matches []MatchInfo `json:"matches"`
+}
+
+// MatchInfo 一場比賽的結果
+type MatchInfo struct {
+	MatchId    int               `json:"matchId"`
+	RoomName   string            `json:"roomName"`
+	WinnerId   string            `json:"winnerId"`  // 中止的比賽為空
+	EndReason  string            `json:"endReason"` // score, surrender, disconnect, timeout, aborted
+	StartedAt  int64             `json:"startedAt"` // Unix 毫秒
+	EndedAt    int64             `json:"endedAt"`   // Unix 毫秒
+	DurationMs int               `json:"durationMs"`
+	Players    []MatchPlayerInfo `json:"players"` // 左邊、右邊的玩家
+}
+
+// MatchPlayerInfo 比賽中的玩家與最後的分數
+type MatchPlayerInfo struct {
+	PlayerId string `json:"playerId"`
+	NickName string `json:"nickName"`
+	Score    int    `json:"score"`
+}
+
+func (m *MatchListMsg) Type() MsgType { return MatchListHeader }
+
+func (m *MatchListMsg) marshal(w *bodyWriter) {
+	w.putCount(len(m.Matches))
+	for _, mi := range m.Matches {
+		w.putInt(mi.MatchId)
+		w.putString(mi.RoomName)
+		w.putString(mi.WinnerId)
+		w.putString(mi.EndReason)
+		w.putInt64(mi.StartedAt)
+		w.putInt64(mi.EndedAt)
+		w.putInt(mi.DurationMs)
+		w.putCount(len(mi.Players))
+		for _, p := range mi.Players {
+			w.putString(p.PlayerId)
+			w.putString(p.NickName)
+			w.putInt(p.Score)
 		}
 	}
+}
 
-	return RoomInfoHeader + payload + PayloadTerminator
+func (m *MatchListMsg) unmarshal(r *bodyReader) {
+	n := r.count()
+	m.Matches = make([]MatchInfo, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		mi := MatchInfo{
+			MatchId:    r.int(),
+			RoomName:   r.string(),
+			WinnerId:   r.string(),
+			EndReason:  r.string(),
+			StartedAt:  r.int64(),
+			EndedAt:    r.int64(),
+			DurationMs: r.int(),
+		}
+		players := r.count()
+		for j := 0; j < players && r.err == nil; j++ {
+			mi.Players = append(mi.Players, MatchPlayerInfo{
+				PlayerId: r.string(),
+				NickName: r.string(),
+				Score:    r.int(),
+			})
+		}
+		m.Matches = append(m.Matches, mi)
+	}
+}
+
+// CreateRoomMsg 創建房間
+type CreateRoomMsg struct {
+	RoomName string `json:"roomName"`
 }
 
-func generateRoomsDetailPayload(room Room) string {
-	roomId := room.RoomId
-	roomName := room.Name
+func (m *CreateRoomMsg) Type() MsgType           { return CreateRoomHeader }
+func (m *CreateRoomMsg) marshal(w *bodyWriter)   { w.putString(m.RoomName) }
+func (m *CreateRoomMsg) unmarshal(r *bodyReader) { m.RoomName = r.string() }
+
+// RoomPlayerInfo 房間詳細內容中的玩家資訊
+type RoomPlayerInfo struct {
+	PlayerId    string `json:"playerId"`
+	NickName    string `json:"nickName"`
+	ReadyStatus int    `json:"readyStatus"`
+	PingMs      int    `json:"pingMs"`   // 來回延遲，尚未量測時為 -1
+	JitterMs    int    `json:"jitterMs"` // 延遲抖動，尚未量測時為 -1
+	Rating      int    `json:"rating"`   // 評分
+}
 
-	var payload string
+// RoomDetailMsg 房間詳細內容
+type RoomDetailMsg struct {
+	RoomId   string           `json:"roomId"`
+	RoomName string           `json:"roomName"`
+	Players  []RoomPlayerInfo `json:"players"`
+}
 
-	if len(room.players) == 0 {
-		payload = fmt.Sprintf("%s,%s,,,,,,", roomId, roomName)
-	} else if len(room.players) == 1 {
-		player1 := room.players[0]
-		payload = fmt.Sprintf("%s,%s,%s,%s,%d,,,", roomId, roomName, player1.IdAkaIpAddress, player1.NickName, player1.RoomReadyStatus)
-	} else {
-		player1 := room.players[0]
-		player2 := room.players[1]
+func (m *RoomDetailMsg) Type() MsgType { return RoomDetailHeader }
 
-		payload = fmt.Sprintf("%s,%s,%s,%s,%d,%s,%s,%d", roomId, roomName,
-			player1.IdAkaIpAddress,
-			player1.NickName,
-			player1.RoomReadyStatus,
-			player2.IdAkaIpAddress,
-			player2.NickName,
-			player2.RoomReadyStatus)
+func (m *RoomDetailMsg) marshal(w *bodyWriter) {
+	w.putString(m.RoomId)
+	w.putString(m.RoomName)
+	w.putCount(len(m.Players))
+	for _, p := range m.Players {
+		w.putString(p.PlayerId)
+		w.putString(p.NickName)
+		w.putInt(p.ReadyStatus)
 	}
 
-	return RoomDetailHeader + payload + PayloadTerminator
+	//以下欄位附加在最後，舊版 Client 會忽略
+	for _, p := range m.Players {
+		w.putInt(p.PingMs)
+		w.putInt(p.JitterMs)
+	}
+	for _, p := range m.Players {
+		w.putInt(p.Rating)
+	}
 }
 
-func generateRoomsFullPayload(roomId string) string {
-	return RoomFullHeader + roomId + PayloadTerminator
+func (m *RoomDetailMsg) unmarshal(r *bodyReader) {
+	m.RoomId = r.string()
+	m.RoomName = r.string()
+	n := r.count()
+	m.Players = make([]RoomPlayerInfo, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Players = append(m.Players, RoomPlayerInfo{
+			PlayerId:    r.string(),
+			NickName:    r.string(),
+			ReadyStatus: r.int(),
+		})
+	}
+
+	if !r.more() {
+		return
+	}
+	for i := range m.Players {
+		m.Players[i].PingMs = r.int()
+		m.Players[i].JitterMs = r.int()
+	}
+
+	if !r.more() {
+		return
+	}
+	for i := range m.Players {
+		m.Players[i].Rating = r.int()
+	}
 }
 
-func generateConnBrokenPayload(brokenIp string) string {
-	return fmt.Sprintf("%s%s%s", ConnBrokenHeader, brokenIp, PayloadTerminator)
+// RoomFullMsg 房間人數已滿
+type RoomFullMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func generateStartBattlePayload(roomId string) string {
-	return fmt.Sprintf("%s%s%s", StartBattleHeader, roomId, PayloadTerminator)
+func (m *RoomFullMsg) Type() MsgType           { return RoomFullHeader }
+func (m *RoomFullMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *RoomFullMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// EnterRoomMsg 進入房間
+type EnterRoomMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func generateBattlePayload(ballX, ballY,
-	player1X, player1Y, player1Score,
-	player2X, player2Y, player2Score int) string {
-	payload := fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d", ballX, ballY,
-		player1X, player1Y, player1Score, player2X, player2Y, player2Score)
-	return BattleSituationHeader + payload + PayloadTerminator
+func (m *EnterRoomMsg) Type() MsgType           { return EnterRoomHeader }
+func (m *EnterRoomMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *EnterRoomMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// LeaveRoomMsg 離開房間
+type LeaveRoomMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func generateOpponentGiveUpBattle(roomId string, interruptSponsor string) string {
-	payload := fmt.Sprintf("%s,%s", roomId, interruptSponsor)
-	return fmt.Sprintf("%s%s%s", GiveUpBattleHeader, payload, PayloadTerminator)
+func (m *LeaveRoomMsg) Type() MsgType           { return LeaveRoomHeader }
+func (m *LeaveRoomMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *LeaveRoomMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// ReadyStartMsg 準備開始&取消準備
+type ReadyStartMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func generateMyselfGiveUpBattle(roomId string) string {
-	return fmt.Sprintf("%s%s%s", GiveUpByMyselfHeader, roomId, PayloadTerminator)
+func (m *ReadyStartMsg) Type() MsgType           { return ReadyStartHeader }
+func (m *ReadyStartMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *ReadyStartMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// WatchRoomMsg 觀戰
+type WatchRoomMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func generateOnlinePlayerCountPayload(onlinePlayerCount int) string {
-	return fmt.Sprintf("%s%d%s", OnlinePlayerCount, onlinePlayerCount, PayloadTerminator)
+func (m *WatchRoomMsg) Type() MsgType           { return WatchRoomHeader }
+func (m *WatchRoomMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *WatchRoomMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
 
+// AddBotMsg 加入電腦玩家(Difficulty 為 "easy", "medium" or "hard")
+type AddBotMsg struct {
+	RoomId     string `json:"roomId"`
+	Difficulty string `json:"difficulty"`
 }
 
-func generateLeaveLobbySuccessPayload() string {
-	return fmt.Sprintf("%s%s", LeaveLobby, PayloadTerminator)
+func (m *AddBotMsg) Type() MsgType { return AddBotHeader }
+
+func (m *AddBotMsg) marshal(w *bodyWriter) {
+	w.putString(m.RoomId)
+	w.putString(m.Difficulty)
 }
 
-func generateBattleOver(roomId string) string {
-	return fmt.Sprintf("%s%s%s", BattleOverHeader, roomId, PayloadTerminator)
+func (m *AddBotMsg) unmarshal(r *bodyReader) {
+	m.RoomId = r.string()
+	m.Difficulty = r.string()
 }
 
-func parseBattleOver(payload string) string {
-	roomId := payload
-	return roomId
+// RemoveBotMsg 移除電腦玩家
+type RemoveBotMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func parsePlayerBattleAction(payload string) string {
-	battleOperation := payload
-	return battleOperation
+func (m *RemoveBotMsg) Type() MsgType           { return RemoveBotHeader }
+func (m *RemoveBotMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *RemoveBotMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// QuickMatchMsg 加入快速配對
+type QuickMatchMsg struct{}
+
+func (m *QuickMatchMsg) Type() MsgType           { return QuickMatchHeader }
+func (m *QuickMatchMsg) marshal(w *bodyWriter)   {}
+func (m *QuickMatchMsg) unmarshal(r *bodyReader) {}
+
+// QuickMatchCancelMsg 取消快速配對(Client請求與Server回覆共用)
+type QuickMatchCancelMsg struct{}
+
+func (m *QuickMatchCancelMsg) Type() MsgType           { return QuickMatchCancelHeader }
+func (m *QuickMatchCancelMsg) marshal(w *bodyWriter)   {}
+func (m *QuickMatchCancelMsg) unmarshal(r *bodyReader) {}
+
+// QueueStatusMsg 快速配對的排隊狀態，配對成功後改收到房間的 RD 與 SB
+type QueueStatusMsg struct {
+	Position        int `json:"position"`        // 排隊順位，從 1 開始
+	QueueLength     int `json:"queueLength"`     // 目前排隊人數
+	EstimatedWaitMs int `json:"estimatedWaitMs"` // 預估還需等待的時間
 }
 
-func parseCreateRoom(payload string) string {
-	roomName := payload
-	return roomName
+func (m *QueueStatusMsg) Type() MsgType { return QueueStatusHeader }
+
+func (m *QueueStatusMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Position)
+	w.putInt(m.QueueLength)
+	w.putInt(m.EstimatedWaitMs)
 }
 
-func parseEnterRoom(payload string) string {
-	roomId := payload
-	return roomId
+func (m *QueueStatusMsg) unmarshal(r *bodyReader) {
+	m.Position = r.int()
+	m.QueueLength = r.int()
+	m.EstimatedWaitMs = r.int()
 }
 
-func parseLeaveRoom(payload string) string {
-	roomId := payload
-	return roomId
+// StartBattleMsg 開始戰鬥(倒數)
+type StartBattleMsg struct {
+	RoomId string `json:"roomId"`
 }
 
-func parseReadyStart(payload string) string {
-	roomId := payload
-	return roomId
+func (m *StartBattleMsg) Type() MsgType           { return StartBattleHeader }
+func (m *StartBattleMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *StartBattleMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// BattleSituationMsg 戰鬥中的狀態
+// Tick 與 ServerTimeMs 讓 Client 在兩個狀態之間內插，LastInputSeq 讓 Client 以預測的球拍位置與 Server 對帳
+type BattleSituationMsg struct {
+	Ball         BallState     `json:"ball"`
+	Paddles      []PaddleState `json:"paddles"`
+	Tick         int           `json:"tick"`         // 此狀態是戰鬥開始後第幾步的模擬結果
+	ServerTimeMs int64         `json:"serverTimeMs"` // 產生此狀態時 Server 的時間(Unix 毫秒)
 }
 
-func parseInterruptBattle(payload string) (string, string) {
-	split := strings.Split(payload, ",")
-	roomId := split[0]
-	playerId := split[1]
-	return roomId, playerId
+// BallState 球的位置
+type BallState struct {
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
-func parseSetPlayerName(payload string) string {
-	playerName := payload
-	return playerName
+// PaddleState 玩家球拍的位置與分數
+type PaddleState struct {
+	PlayerId     string `json:"playerId"`
+	X            int    `json:"x"`
+	Y            int    `json:"y"`
+	Score        int    `json:"score"`
+	LastInputSeq int    `json:"lastInputSeq"` // Server 已處理的此玩家最後一個 BA 序號
+	PingMs       int    `json:"pingMs"`       // 此玩家的來回延遲，尚未量測時為 -1
 }
 
-func removeHeaderTerminator(payload string) string {
-	return payload[2 : len(payload)-1]
+func (m *BattleSituationMsg) Type() MsgType { return BattleSituationHeader }
+
+func (m *BattleSituationMsg) marshal(w *bodyWriter) {
+	w.putInt(m.Ball.X)
+	w.putInt(m.Ball.Y)
+	w.putCount(len(m.Paddles))
+	for _, p := range m.Paddles {
+		w.putString(p.PlayerId)
+		w.putInt(p.X)
+		w.putInt(p.Y)
+		w.putInt(p.Score)
+	}
+
+	//以下欄位附加在最後，舊版 Client 會忽略
+	w.putInt(m.Tick)
+	w.putInt64(m.ServerTimeMs)
+	for _, p := range m.Paddles {
+		w.putInt(p.LastInputSeq)
+		w.putInt(p.PingMs)
+	}
+}
+
+func (m *BattleSituationMsg) unmarshal(r *bodyReader) {
+	m.Ball.X = r.int()
+	m.Ball.Y = r.int()
+	n := r.count()
+	m.Paddles = make([]PaddleState, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Paddles = append(m.Paddles, PaddleState{PlayerId: r.string(), X: r.int(), Y: r.int(), Score: r.int()})
+	}
+
+	if !r.more() {
+		return
+	}
+	m.Tick = r.int()
+	m.ServerTimeMs = r.int64()
+	for i := range m.Paddles {
+		m.Paddles[i].LastInputSeq = r.int()
+		m.Paddles[i].PingMs = r.int()
+	}
+}
+
+// BattleActionMsg 戰鬥中玩家的移動操作("U", "D" or "I")
+type BattleActionMsg struct {
+	Action string `json:"action"`
+	Seq    int    `json:"seq"` // Client 遞增的輸入序號，舊版 Client 沒有此欄位(0)
+}
+
+func (m *BattleActionMsg) Type() MsgType { return BattleActionHeader }
+
+func (m *BattleActionMsg) marshal(w *bodyWriter) {
+	w.putString(m.Action)
+	w.putInt(m.Seq)
+}
+
+func (m *BattleActionMsg) unmarshal(r *bodyReader) {
+	m.Action = r.string()
+	if r.more() {
+		m.Seq = r.int()
+	}
+}
+
+// BattleOverMsg 戰鬥結束
+type BattleOverMsg struct {
+	RoomId string `json:"roomId"`
+}
+
+func (m *BattleOverMsg) Type() MsgType           { return BattleOverHeader }
+func (m *BattleOverMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *BattleOverMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+// GiveUpBattleMsg 中斷戰鬥(投降)
+type GiveUpBattleMsg struct {
+	RoomId   string `json:"roomId"`
+	PlayerId string `json:"playerId"`
+}
+
+func (m *GiveUpBattleMsg) Type() MsgType { return GiveUpBattleHeader }
+
+func (m *GiveUpBattleMsg) marshal(w *bodyWriter) {
+	w.putString(m.RoomId)
+	w.putString(m.PlayerId)
+}
+
+func (m *GiveUpBattleMsg) unmarshal(r *bodyReader) {
+	m.RoomId = r.string()
+	m.PlayerId = r.string()
+}
+
+// GiveUpByMyselfMsg 自行發起投降戰鬥
+type GiveUpByMyselfMsg struct {
+	RoomId string `json:"roomId"`
+}
+
+func (m *GiveUpByMyselfMsg) Type() MsgType           { return GiveUpByMyselfHeader }
+func (m *GiveUpByMyselfMsg) marshal(w *bodyWriter)   { w.putString(m.RoomId) }
+func (m *GiveUpByMyselfMsg) unmarshal(r *bodyReader) { m.RoomId = r.string() }
+
+func generateWelcomePayload(version int, features []string, tickInterval time.Duration) *WelcomeMsg {
+	return &WelcomeMsg{
+		Version:        version,
+		Features:       features,
+		TickIntervalMs: int(tickInterval / time.Millisecond),
+		WindowWidth:    windowWidth,
+		WindowHeight:   windowHeight,
+		PaddleHeight:   PaddleHeight,
+	}
+}
+
+func generateHelloRejectPayload(code int, reason string) *HelloRejectMsg {
+	return &HelloRejectMsg{Code: code, Reason: reason, ServerVersion: ProtocolVersion}
+}
+
+func generateErrorPayload(code int, reason string, requestHeader MsgType) Message {
+	header := ""
+	if requestHeader != 0 {
+		header = requestHeader.String()
+	}
+	return &ErrorMsg{Code: code, Reason: reason, RequestHeader: header}
+}
+
+func generateAccountInfoPayload(account *Account) Message {
+	return &AccountInfoMsg{
+		Username: account.Username,
+		NickName: account.NickName,
+		Rating:   roundRating(account.Rating),
+		Wins:     account.Wins,
+		Losses:   account.Losses,
+	}
+}
+
+func generateMatchListPayload(records []*MatchRecord) Message {
+	matches := make([]MatchInfo, 0, len(records))
+	for _, record := range records {
+		players := make([]MatchPlayerInfo, 0, len(record.Players))
+		for _, p := range record.Players {
+			players = append(players, MatchPlayerInfo{PlayerId: p.PlayerId, NickName: p.NickName, Score: p.Score})
+		}
+		matches = append(matches, MatchInfo{
+			MatchId:    int(record.Id),
+			RoomName:   record.RoomName,
+			WinnerId:   record.WinnerId,
+			EndReason:  record.EndReason,
+			StartedAt:  record.StartedAt.UnixMilli(),
+			EndedAt:    record.EndedAt.UnixMilli(),
+			DurationMs: int(record.Duration() / time.Millisecond),
+			Players:    players,
+		})
+	}
+	return &MatchListMsg{Matches: matches}
+}
+
+func generateRoomsListPayload(rooms []RoomInfo) Message {
+	return &RoomListMsg{Rooms: rooms}
+}
+
+func generateRoomsDetailPayload(room Room) Message {
+	players := make([]RoomPlayerInfo, 0, len(room.players))
+	for _, p := range room.players {
+		pingMs, jitterMs := p.latencyMs()
+		players = append(players, RoomPlayerInfo{
+			PlayerId:    p.Id,
+			NickName:    p.NickName,
+			ReadyStatus: p.RoomReadyStatus,
+			PingMs:      pingMs,
+			JitterMs:    jitterMs,
+			Rating:      roundRating(p.Rating),
+		})
+	}
+
+	return &RoomDetailMsg{RoomId: room.RoomId, RoomName: room.Name, Players: players}
+}
+
+func generateRoomsFullPayload(roomId string) Message {
+	return &RoomFullMsg{RoomId: roomId}
+}
+
+func generateConnBrokenPayload(playerId string, session Session) Message {
+	return &ConnBrokenMsg{PlayerId: playerId, session: session}
+}
+
+func generateReconnectTimeoutPayload(playerId string) Message {
+	return &ReconnectTimeoutMsg{PlayerId: playerId}
+}
+
+func generatePlayerDisconnectedPayload(playerId string) Message {
+	return &PlayerDisconnectedMsg{PlayerId: playerId, GracePeriodMs: int(ReconnectGracePeriod / time.Millisecond)}
+}
+
+func generatePlayerResumedPayload(playerId string) Message {
+	return &PlayerResumedMsg{PlayerId: playerId}
+}
+
+func generatePingPayload(seq int) Message {
+	return &PingMsg{Seq: seq, ServerTimeMs: time.Now().UnixMilli()}
+}
+
+func generateServerShutdownPayload(reason string, gracePeriod time.Duration) Message {
+	return &ServerShutdownMsg{Reason: reason, GracePeriodMs: int(gracePeriod / time.Millisecond)}
+}
+
+func generateQuickMatchCancelPayload() Message {
+	return &QuickMatchCancelMsg{}
+}
+
+func generateQueueStatusPayload(position int, queueLength int, estimatedWait time.Duration) Message {
+	return &QueueStatusMsg{Position: position, QueueLength: queueLength, EstimatedWaitMs: int(estimatedWait / time.Millisecond)}
+}
+
+func generateStartBattlePayload(roomId string) Message {
+	return &StartBattleMsg{RoomId: roomId}
+}
+
+func generateBattlePayload(room *Room) Message {
+	ball := room.Ball
+
+	paddles := make([]PaddleState, 0, len(room.players))
+	for _, p := range room.players {
+		pingMs, _ := p.latencyMs()
+		paddles = append(paddles, PaddleState{PlayerId: p.Id, X: roundPosition(p.Col), Y: roundPosition(p.Row), Score: p.CurrentScore,
+			LastInputSeq: p.lastInputSeq, PingMs: pingMs})
+	}
+
+	return &BattleSituationMsg{
+		Ball:         BallState{X: roundPosition(ball.Col), Y: roundPosition(ball.Row)},
+		Paddles:      paddles,
+		Tick:         room.simTick,
+		ServerTimeMs: time.Now().UnixMilli(),
+	}
+}
+
+// roundPosition 傳送給 Client 的位置取整數
+func roundPosition(v float64) int {
+	return int(math.Round(v))
+}
+
+func generateOpponentGiveUpBattle(roomId string, interruptSponsor string) Message {
+	return &GiveUpBattleMsg{RoomId: roomId, PlayerId: interruptSponsor}
+}
+
+func generateMyselfGiveUpBattle(roomId string) Message {
+	return &GiveUpByMyselfMsg{RoomId: roomId}
+}
+
+func generateOnlinePlayerCountPayload(onlinePlayerCount int) Message {
+	return &OnlinePlayerCountMsg{Count: onlinePlayerCount}
+}
+
+func generateLeaveLobbySuccessPayload() Message {
+	return &LeaveLobbyMsg{}
+}
+
+func generateBattleOver(roomId string) Message {
+	return &BattleOverMsg{RoomId: roomId}
+}
+
+// redacted 取代 Log 中的 SessionToken 與密碼
+const redacted = "[REDACTED]"
+
+// msgContent 用於 Log 輸出訊息內容，SessionToken 與密碼不寫入 Log(拿到 token 即可接管玩家)
+func msgContent(msg Message) string {
+	switch m := msg.(type) {
+	case *WelcomeMsg:
+		c := *m
+		c.SessionToken = redactSecret(c.SessionToken)
+		msg = &c
+	case *HelloMsg:
+		c := *m
+		c.SessionToken = redactSecret(c.SessionToken)
+		msg = &c
+	case *RegisterMsg:
+		c := *m
+		c.Password = redactSecret(c.Password)
+		msg = &c
+	case *LoginMsg:
+		c := *m
+		c.Password = redactSecret(c.Password)
+		msg = &c
+	}
+	return fmt.Sprintf("%s%+v", msg.Type(), msg)
+}
+
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
 }
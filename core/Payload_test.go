@@ -0,0 +1,27 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMsgContentRedactsSecrets(t *testing.T) {
+	msgs := []Message{
+		&WelcomeMsg{PlayerId: "p1", SessionToken: "secret-token"},
+		&HelloMsg{Version: ProtocolVersion, SessionToken: "secret-token"},
+		&RegisterMsg{Username: "alice", Password: "secret-token"},
+		&LoginMsg{Username: "alice", Password: "secret-token"},
+	}
+	for _, msg := range msgs {
+		content := msgContent(msg)
+		if strings.Contains(content, "secret-token") || !strings.Contains(content, redacted) {
+			t.Errorf("msgContent(%T) = %s, want secret redacted", msg, content)
+		}
+	}
+
+	//Log 用的是複本，送出的訊息不受影響
+	welcome := msgs[0].(*WelcomeMsg)
+	if welcome.SessionToken != "secret-token" {
+		t.Errorf("SessionToken = %q after msgContent, want unchanged", welcome.SessionToken)
+	}
+}
@@ -0,0 +1,80 @@
+package core
+
+import "math"
+
+// 發球方向的規則
+const ServeAlternate = 0  // 每回合輪流向左右發球
+const ServeToConceder = 1 // 向上一回合失分的玩家發球
+
+// Physics 房間的球速與反彈設定，速度單位為每秒移動的距離
+type Physics struct {
+	ServeSpeed     float64 // 每回合發球的速度
+	SpeedIncrement float64 // 每次擊中球拍後增加的速度
+	MaxSpeed       float64 // 球速上限
+	ServeAngle     float64 // 發球與水平方向的夾角(度)
+	MaxBounceAngle float64 // 擊中球拍邊緣時反彈與水平方向的最大夾角(度)，擊中中央時水平反彈
+	ServeRule      int     // 發球方向的規則(ServeAlternate/ServeToConceder)
+	PaddleSpeed    float64 // 連續輸入時球拍移動的速度
+}
+
+// DefaultPhysics 預設的物理設定，發球速度與原本每 65ms 移動 (10, 10) 相同
+var DefaultPhysics = Physics{
+	ServeSpeed:     220,
+	SpeedIncrement: 25,
+	MaxSpeed:       650,
+	ServeAngle:     45,
+	MaxBounceAngle: 60,
+	ServeRule:      ServeAlternate,
+	PaddleSpeed:    450,
+}
+
+// physicsFor 新房間使用的物理設定
+func (s *Server) physicsFor(roomName string) Physics {
+	if s.roomPhysics != nil {
+		return s.roomPhysics(roomName)
+	}
+	return s.physics
+}
+
+// serve 將球放回中央，依發球規則決定方向並以發球速度發球
+// conceded 為上一回合失分的玩家位置(-1 左、1 右)，第一回合為 0
+func (r *Room) serve(conceded float64) {
+	physics := r.physics
+
+	switch {
+	case conceded == 0:
+		//第一回合向右發球
+		r.serveDir = 1
+	case physics.ServeRule == ServeToConceder:
+		r.serveDir = conceded
+	default:
+		r.serveDir = -r.serveDir
+	}
+
+	ball := r.Ball
+	ball.Row = windowHeight / 2
+	ball.Col = windowWidth / 2
+	ball.speed = physics.ServeSpeed
+
+	angle := physics.ServeAngle * math.Pi / 180
+	ball.VelCol = r.serveDir * ball.speed * math.Cos(angle)
+	ball.VelRow = ball.speed * math.Sin(angle)
+}
+
+// deflect 球擊中球拍，依擊中的位置決定反彈角度，並提高球速直到上限
+// dir 為反彈後的水平方向(1 向右、-1 向左)
+func (r *Room) deflect(paddle *Player, dir float64) {
+	physics := r.physics
+	ball := r.Ball
+
+	//擊中位置相對球拍中央的偏移，-1 為上緣、1 為下緣
+	half := float64(paddle.Height) / 2
+	offset := (ball.Row - (paddle.Row + half)) / half
+	offset = math.Max(-1, math.Min(1, offset))
+
+	ball.speed = math.Min(ball.speed+physics.SpeedIncrement, physics.MaxSpeed)
+
+	angle := offset * physics.MaxBounceAngle * math.Pi / 180
+	ball.VelCol = dir * ball.speed * math.Cos(angle)
+	ball.VelRow = ball.speed * math.Sin(angle)
+}
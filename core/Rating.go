@@ -0,0 +1,96 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultRating 新玩家的評分
+const DefaultRating = 1500
+
+// RatingK 每場比賽評分變動的上限(Elo 的 K 值)
+const RatingK = 32
+
+// 快速配對可接受的評分差距，從 QuickMatchInitialGap 開始隨排隊時間每秒增加 QuickMatchGapGrowth
+const QuickMatchInitialGap = 100
+const QuickMatchGapGrowth = 50
+
+// QuickMatchRatingTie 評分差距相差不超過此值的對手視為一樣接近，改選延遲較低的對手
+const QuickMatchRatingTie = 25
+
+// expectedScore 以 Elo 計算評分 rating 的玩家對上 opponent 的預期勝率
+func expectedScore(rating float64, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+// updateRatings 比賽分出勝負後更新雙方的評分，與電腦玩家的比賽不計分
+func (r *Room) updateRatings(winner *Player, loser *Player) {
+	if winner.isBot() || loser.isBot() {
+		return
+	}
+
+	winnerBefore, loserBefore := winner.Rating, loser.Rating
+	delta := RatingK * (1 - expectedScore(winnerBefore, loserBefore))
+	winner.Rating += delta
+	loser.Rating -= delta
+	r.server.saveResults(winner, loser)
+
+	r.server.logger.Info(fmt.Sprintf(logger.RatingUpdatedMsg, r.RoomId,
+		winner.Id, roundRating(winnerBefore), roundRating(winner.Rating),
+		loser.Id, roundRating(loserBefore), roundRating(loser.Rating)))
+}
+
+// roundRating 傳送給 Client 的評分取整數
+func roundRating(rating float64) int {
+	return int(math.Round(rating))
+}
+
+// allowedGap 此玩家目前可接受的評分差距，排隊越久差距越大
+func (t *matchTicket) allowedGap(now time.Time) float64 {
+	return QuickMatchInitialGap + QuickMatchGapGrowth*now.Sub(t.since).Seconds()
+}
+
+// findMatch 從排隊最久的玩家開始，找出評分差距在可接受範圍內且最接近的對手，差距相近時選延遲較低的對手
+// 雙方中任一方可接受的差距即可配對，讓排隊很久的玩家不會一直等不到對手
+func (m *matchmaker) findMatch(now time.Time) (*matchTicket, *matchTicket, bool) {
+	for i, ticket := range m.queue {
+		var best *matchTicket
+		var bestGap float64
+		for j, other := range m.queue {
+			if i == j {
+				continue
+			}
+			gap := math.Abs(ticket.player.Rating - other.player.Rating)
+			if gap > math.Max(ticket.allowedGap(now), other.allowedGap(now)) {
+				continue
+			}
+			if best == nil || closerMatch(gap, other.player, bestGap, best.player) {
+				best, bestGap = other, gap
+			}
+		}
+		if best != nil {
+			return ticket, best, true
+		}
+	}
+	return nil, nil, false
+}
+
+// closerMatch 評分差距為 gap 的 player 是否比目前最好的對手更適合
+// 差距相差超過 QuickMatchRatingTie 時選差距小的，否則選 RTT 較低的，還沒有量測結果的玩家排在最後
+func closerMatch(gap float64, player *Player, bestGap float64, best *Player) bool {
+	if math.Abs(gap-bestGap) > QuickMatchRatingTie {
+		return gap < bestGap
+	}
+
+	rtt, _, ok := player.Latency()
+	bestRtt, _, bestOk := best.Latency()
+	if ok != bestOk {
+		return ok
+	}
+	if rtt != bestRtt {
+		return rtt < bestRtt
+	}
+	return gap < bestGap
+}
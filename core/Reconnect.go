@@ -0,0 +1,148 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"time"
+)
+
+// ReconnectGracePeriod 戰鬥中斷線的玩家需在此時間內重連，否則判負
+const ReconnectGracePeriod = 30 * time.Second
+
+// 重連失敗的原因
+const RejectSessionExpired = 4 // SessionToken 不存在或已超過重連時限
+
+// handleConnBroken 戰鬥中斷線的玩家保留在房間中等待重連，其他場景離開房間後交給大廳中止連線
+func (r *Room) handleConnBroken(m *ConnBrokenMsg) {
+	//觀戰的玩家直接離開房間
+	if r.isSpectator(m.PlayerId) {
+		for _, spectator := range r.spectators {
+			if spectator.Id == m.PlayerId && spectator.Session() == m.session {
+				r.unwatch(spectator)
+				break
+			}
+		}
+		r.server.toLobby(m)
+		return
+	}
+
+	player := r.findPlayer(m.PlayerId)
+	//已經不在房間中的玩家交給大廳處理
+	if player == nil {
+		r.server.toLobby(m)
+		return
+	}
+
+	//已在等待重連，或是玩家已用新連線恢復後舊連線才送來的通知
+	if player.Disconnected || player.Session() != m.session {
+		return
+	}
+
+	if player.Scene != SceneBattle {
+		r.leave(player)
+		r.server.toLobby(m)
+		return
+	}
+
+	player.Session().Close()
+	player.setDisconnected(true)
+	//球拍停止移動，房間暫停直到重連或判負
+	player.VelRow = 0
+
+	if !player.canResume() {
+		//舊版Client無法恢復連線，直接判負
+		r.setLoser(player.Id, MatchEndDisconnect)
+		r.server.logger.Info(fmt.Sprintf(logger.PlayerForfeitMsg, player.Id, r.RoomId))
+		return
+	}
+
+	playerId := player.Id
+	player.reconnectTimer = time.AfterFunc(ReconnectGracePeriod, func() {
+		r.send(generateReconnectTimeoutPayload(playerId))
+	})
+
+	r.notifyBattlePlayer(generatePlayerDisconnectedPayload(player.Id))
+	r.server.logger.Info(fmt.Sprintf(logger.PlayerWaitReconnectMsg, player.Id, r.RoomId, ReconnectGracePeriod))
+}
+
+// handleReconnectTimeout 斷線玩家未在時限內重連，判對手獲勝
+func (r *Room) handleReconnectTimeout(m *ReconnectTimeoutMsg) {
+	player := r.findPlayer(m.PlayerId)
+
+	//已經重連成功
+	if player == nil || !player.Disconnected {
+		return
+	}
+
+	//遊戲迴圈偵測到勝負後會送出 BO，並由 removeDisconnectedPlayers 清除此玩家
+	r.setLoser(player.Id, MatchEndTimeout)
+	r.server.logger.Info(fmt.Sprintf(logger.PlayerForfeitMsg, player.Id, r.RoomId))
+}
+
+// resumePlayer 將新連線綁定回斷線前的玩家，並送出完整狀態讓 Client 回到原本的房間與戰鬥
+func (r *Room) resumePlayer(player *Player, session Session, welcome *WelcomeMsg) bool {
+	if !r.hasPlayer(player.Id) || !player.Disconnected {
+		return false
+	}
+
+	player.resumeSession(session, welcome.Version, welcome.Features)
+	player.setDisconnected(false)
+	player.touch()
+	//新連線的 Client 可能重新開始計算輸入序號
+	player.lastInputSeq = 0
+	if player.reconnectTimer != nil {
+		player.reconnectTimer.Stop()
+		player.reconnectTimer = nil
+	}
+
+	welcome.PlayerId = player.Id
+	welcome.SessionToken = player.SessionToken
+	welcome.Scene = player.Scene
+	welcome.RoomId = r.RoomId
+	r.server.sendMsg(player, welcome)
+
+	r.server.sendMsg(player, generateRoomsDetailPayload(*r))
+	if player.Scene == SceneBattle && r.Ball != nil {
+		r.server.sendMsg(player, generateBattlePayload(r))
+	}
+	r.notifyBattlePlayer(generatePlayerResumedPayload(player.Id))
+	r.server.logger.Info(fmt.Sprintf(logger.PlayerResumedMsg, player.Id, session.RemoteAddr()))
+	return true
+}
+
+// removeDisconnectedPlayers 戰鬥結束後移除未重連的玩家，並交給大廳移除
+func (r *Room) removeDisconnectedPlayers() {
+	for _, player := range append([]*Player(nil), r.players...) {
+		if !player.Disconnected {
+			continue
+		}
+		if player.reconnectTimer != nil {
+			player.reconnectTimer.Stop()
+		}
+		r.removeRoomPlayer(player.Id)
+		player.setRoom(nil)
+		r.server.toLobby(generateConnBrokenPayload(player.Id, player.Session()))
+	}
+}
+
+// resumePlayer 以 SessionToken 找出正在等待重連的玩家，由其房間恢復連線後開始監聽玩家操作
+func (s *Server) resumePlayer(token string, session Session, welcome *WelcomeMsg) bool {
+	player := s.findPlayer(token)
+	if player == nil {
+		return false
+	}
+
+	room := player.currentRoom()
+	if room == nil || !room.resume(player, session, welcome) {
+		return false
+	}
+
+	//開始監聽玩家操作事件
+	go s.listenPlayerOperation(session, player)
+	return true
+}
+
+// canResume 舊版Client沒有 SessionToken 可以恢復連線
+func (p *Player) canResume() bool {
+	return p.ProtocolVersion != LegacyProtocolVersion
+}
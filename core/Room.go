@@ -7,16 +7,24 @@ import (
 	"time"
 )
 
-const FinalScore = 12       // 遊戲結束分數
+const FinalScore = 12       // 預設的遊戲結束分數
 const BallSymbol = 0x25CF   // 球符號
 const PaddleSymbol = 0x2588 // 球拍符號
 const PaddleHeight = 150    // 球拍高度
-const BallVelocityRow = 10
-const BallVelocityCol = 10
+
+const TickInterval = 65 * time.Millisecond // 預設每一步模擬的間隔
 
 const windowHeight = 600
 const windowWidth = 800
 
+// MaxRoomNameLength 房間名稱的最大字元數
+const MaxRoomNameLength = 30
+
+// BA 的移動操作
+const ActionUp = "U"   // 往上(連續輸入時為按住上)
+const ActionDown = "D" // 往下(連續輸入時為按住下)
+const ActionIdle = "I" // 放開(僅連續輸入)
+
 const RoomStatusWaiting = 0
 const RoomStatusPlaying = 1
 
@@ -27,13 +35,34 @@ type Room struct {
 	CreateDate string
 	Creator    *Player
 
-	players []*Player
+	players    []*Player
+	spectators []*Player // 觀戰的玩家，只接收戰鬥狀態與結果
 
 	Ball *Ball
+
+	physics  Physics // 房間的球速與反彈設定
+	serveDir float64 // 上一回合的發球方向(1 向右、-1 向左)
+	simTick  int     // 戰鬥開始後已模擬的步數
+
+	// server 房間所屬的伺服器(遊戲設定與大廳 inbox)
+	server *Server
+
+	//以下只由房間 goroutine(run)存取
+	inbox     chan interface{}
+	done      chan struct{}    // 房間 goroutine 已結束
+	countdown <-chan time.Time // 開始戰鬥前的倒數
+	loop      *gameLoop        // 戰鬥中的遊戲迴圈
+	tick      <-chan time.Time
+	abort     <-chan struct{} // 伺服器關閉期限已到，中止戰鬥
+
+	battleStart time.Time // 這場戰鬥開始的時間
+	endReason   string    // 投降或斷線判負時的結束原因，得分結束時為空
+	endScores   []int     // 投降或斷線判負前雙方的分數
 }
 
+// startGame 產生遊戲元素並開始固定步長的遊戲迴圈，每個 tick 由房間 goroutine 呼叫 updateBattle
 func (r *Room) startGame() {
-	logger.Log.Info(fmt.Sprintf("Room id:%s 遊戲開始！", r.RoomId))
+	r.server.logger.Info(fmt.Sprintf("Room id:%s 遊戲開始！", r.RoomId))
 
 	player1 := r.players[0]
 	player2 := r.players[1]
@@ -41,27 +70,19 @@ func (r *Room) startGame() {
 	player1.Scene = SceneBattle
 	player2.Scene = SceneBattle
 
+	r.battleStart = time.Now()
+	r.endReason = ""
+	r.endScores = nil
+
 	//產生遊戲元素
 	r.spawnGameElement()
 
-	for {
-		//檢查房間狀態(是否有人離線)
-		state := r.updateState()
-		if state == false {
-			return
-		}
-		conn1SendStatus := sendGameState(player1.Conn, r)
-		conn2SendStatus := sendGameState(player2.Conn, r)
-
-		if conn1SendStatus == ConnBroken || conn2SendStatus == ConnBroken {
-			return
-		}
-		time.Sleep(65 * time.Millisecond)
-	}
+	r.loop = newGameLoop(r.server.tickInterval, r.server.sendInterval)
+	r.tick = r.loop.ticker.C
 }
 
 func (r *Room) spawnGameElement() {
-	paddleStart := windowHeight/2 - PaddleHeight/2
+	paddleStart := float64(windowHeight/2 - PaddleHeight/2)
 
 	player1 := r.players[0]
 	player2 := r.players[1]
@@ -75,6 +96,7 @@ func (r *Room) spawnGameElement() {
 	player1.VelRow = 0
 	player1.VelCol = 0
 	player1.CurrentScore = 0
+	player1.lastInputSeq = 0
 	player1.RightOrLeft = "left"
 
 	player2.Row = paddleStart
@@ -85,85 +107,93 @@ func (r *Room) spawnGameElement() {
 	player2.VelRow = 0
 	player2.VelCol = 0
 	player2.CurrentScore = 0
+	player2.lastInputSeq = 0
 	player2.RightOrLeft = "right"
 
-	//產生球
-	ball := &Ball{
-		GameObject: GameObject{Row: windowHeight / 2, Col: windowWidth / 2, Width: 1, Height: 1, Symbol: BallSymbol,
-			VelRow: BallVelocityRow, VelCol: BallVelocityCol},
-	}
+	r.simTick = 0
 
-	r.Ball = ball
+	//產生球並發球
+	r.Ball = &Ball{
+		GameObject: GameObject{Width: 1, Height: 1, Symbol: BallSymbol},
+	}
+	r.serve(0)
 }
 
+// resetRoomStatus 玩家回到未準備狀態，電腦玩家永遠是準備狀態
 func (r *Room) resetRoomStatus() {
-	player1 := r.players[0]
-	player2 := r.players[1]
-
-	player1.RoomReadyStatus = 0
-	player1.CurrentScore = 0
-	player2.RoomReadyStatus = 0
-	player2.CurrentScore = 0
+	for _, player := range r.players {
+		player.RoomReadyStatus = 0
+		if player.isBot() {
+			player.RoomReadyStatus = 1
+		}
+		player.CurrentScore = 0
+	}
 }
 
-func (r *Room) updateState() bool {
+// updateState 模擬經過 dt 秒後的遊戲狀態
+func (r *Room) updateState(dt float64) bool {
 	if len(r.players) < 2 {
 		return false
 	}
+	//有玩家斷線等待重連時暫停，只檢查是否已判定勝負(e.g. 重連逾時)
+	if r.isWaitingReconnect() {
+		return r.checkGameOver()
+	}
+
+	//電腦玩家與 Client 一樣，在移動前送出按住的方向
+	r.driveBots(dt)
+
 	player1 := r.players[0]
 	player2 := r.players[1]
-	ball := r.Ball
 
 	//玩家ㄧ球拍
-	player1.Row += player1.VelRow
-	player1.Col += player1.VelCol
+	player1.Row += player1.VelRow * dt
+	player1.Col += player1.VelCol * dt
+	clampPaddle(player1)
 	//玩家二球拍
-	player2.Row += player2.VelRow
-	player2.Col += player2.VelCol
+	player2.Row += player2.VelRow * dt
+	player2.Col += player2.VelCol * dt
+	clampPaddle(player2)
 
-	//球
-	ball.Row += ball.VelRow
-	ball.Col += ball.VelCol
-
-	//檢查有沒有撞到上下牆壁
-	if r.isCollidesWithWall() {
-		ball.VelRow = -ball.VelRow
-	}
-	//檢查是否有碰到球拍
-	if r.isTouchPaddle() {
-		ball.VelCol = -ball.VelCol
-	}
+	//球，碰到上下牆壁或球拍時反彈(球拍依擊中位置反彈並加速)
+	r.moveBall(dt)
 
 	if r.isBallOutSide() {
 		r.calculateScore()
 		r.resetNewRound()
 	}
 
+	return r.checkGameOver()
+}
+
+// checkGameOver 已分出勝負時回傳 false
+func (r *Room) checkGameOver() bool {
 	over, _ := r.isGameOver()
 	if over == true {
-		msg := generateBattleOver(r.RoomId)
-		r.RoomStatus = RoomStatusWaiting
-		roomChanMsg <- msg
 		return false
 	}
 
 	return true
 }
 
+// resetNewRound 球速重設，依發球規則開始新的回合
 func (r *Room) resetNewRound() {
-	ball := r.Ball
-	ball.Row = windowHeight / 2
-	ball.Col = windowWidth / 2
+	//球從左邊出界代表左邊的玩家失分
+	conceded := 1.0
+	if r.Ball.Col < 0 {
+		conceded = -1
+	}
+	r.serve(conceded)
 }
 
 func (r *Room) isGameOver() (bool, *Player) {
 	player1 := r.players[0]
 	player2 := r.players[1]
 
-	if player1.CurrentScore == FinalScore {
+	if player1.CurrentScore == r.server.finalScore {
 		return true, player1
 	}
-	if player2.CurrentScore == FinalScore {
+	if player2.CurrentScore == r.server.finalScore {
 		return true, player2
 	}
 	return false, nil
@@ -187,30 +217,51 @@ func (r *Room) calculateScore() {
 	}
 }
 
-func (r *Room) isTouchPaddle() bool {
-	player1 := r.players[0]
-	player2 := r.players[1]
-	ball := r.Ball
+// handleBattleAction 玩家的移動操作，支援連續輸入的 Client 送來按住的方向，舊版 Client 每次操作移動固定距離
+// seq 不是 0 時記錄為已處理的輸入，在下一個 BS 中回報給 Client
+func (r *Room) handleBattleAction(player *Player, action string, seq int) {
+	if seq != 0 {
+		//比已處理的序號舊的輸入不再套用
+		if seq <= player.lastInputSeq {
+			return
+		}
+		player.lastInputSeq = seq
+	}
 
-	if ball.Col+ball.VelCol <= player1.Col &&
-		(ball.Row > player1.Row && ball.Row <= player1.Row+PaddleHeight) {
-		return true
-	} else if ball.Col+ball.VelCol >= player2.Col &&
-		(ball.Row > player2.Row && ball.Row <= player2.Row+PaddleHeight) {
-		return true
+	if !player.hasFeature(FeatureContinuousInput) {
+		switch action {
+		case ActionUp:
+			player.MoveUp()
+		case ActionDown:
+			player.MoveDown()
+		}
+		clampPaddle(player)
+		return
+	}
+
+	//球拍在之後的每一步以固定速度移動，直到放開
+	switch action {
+	case ActionUp:
+		player.VelRow = -r.paddleSpeed(player)
+	case ActionDown:
+		player.VelRow = r.paddleSpeed(player)
+	case ActionIdle:
+		player.VelRow = 0
 	}
-	return false
 }
 
-func (r *Room) isCollidesWithWall() bool {
-	ball := r.Ball
-	return ball.Row+ball.VelRow < 0 || ball.Row+ball.VelRow >= windowHeight
+// paddleSpeed 連續輸入時此玩家球拍移動的速度
+func (r *Room) paddleSpeed(player *Player) float64 {
+	if player.maxPaddleSpeed > 0 && player.maxPaddleSpeed < r.physics.PaddleSpeed {
+		return player.maxPaddleSpeed
+	}
+	return r.physics.PaddleSpeed
 }
 
 func (r *Room) updatePlayerReadyStatus(playerId string) {
 	var toChangeIndex int
 	for i, p := range r.players {
-		if p.IdAkaIpAddress == playerId {
+		if p.Id == playerId {
 			toChangeIndex = i
 			break
 		}
@@ -221,9 +272,9 @@ func (r *Room) updatePlayerReadyStatus(playerId string) {
 
 	//Log
 	if player.RoomReadyStatus == 1 {
-		logger.Log.Info(fmt.Sprintf(logger.PlayerPressReadyMsg, playerId, r.RoomId))
+		r.server.logger.Info(fmt.Sprintf(logger.PlayerPressReadyMsg, playerId, r.RoomId))
 	} else {
-		logger.Log.Info(fmt.Sprintf(logger.PlayerCancelReadyMsg, playerId, r.RoomId))
+		r.server.logger.Info(fmt.Sprintf(logger.PlayerCancelReadyMsg, playerId, r.RoomId))
 	}
 }
 
@@ -235,7 +286,7 @@ func (r *Room) updateRoomStatus(roomStatus int) {
 func (r *Room) removeRoomPlayer(playerId string) {
 	var index int
 	for i, player := range r.players {
-		if player.IdAkaIpAddress == playerId {
+		if player.Id == playerId {
 			index = i
 			break
 		}
@@ -243,22 +294,66 @@ func (r *Room) removeRoomPlayer(playerId string) {
 	r.players = append(r.players[:index], r.players[index+1:]...)
 }
 
-func (r *Room) setLoser(playerId string) {
+func (r *Room) isWaitingReconnect() bool {
+	for _, player := range r.players {
+		if player.Disconnected {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Room) isAllReady() bool {
+	if len(r.players) < 2 {
+		return false
+	}
+	for _, player := range r.players {
+		if player.RoomReadyStatus != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Room) findPlayer(playerId string) *Player {
+	for _, player := range r.players {
+		if player.Id == playerId {
+			return player
+		}
+	}
+	return nil
+}
+
+func (r *Room) hasPlayer(playerId string) bool {
+	for _, player := range r.players {
+		if player.Id == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+// setLoser 判此玩家落敗(對手直接達到結束分數)，並記錄結束原因與判定前的分數，已判定過時忽略
+func (r *Room) setLoser(playerId string, reason string) {
+	if r.endReason != "" {
+		return
+	}
+	r.endReason = reason
+	r.endScores = []int{r.players[0].CurrentScore, r.players[1].CurrentScore}
+
 	var index int
 	for i, player := range r.players {
-		if player.IdAkaIpAddress != playerId {
+		if player.Id != playerId {
 			index = i
 			break
 		}
 	}
 	player := r.players[index]
-	player.CurrentScore = FinalScore
-}
-
-func isTouchBottomBorder(paddle *Player) bool {
-	return (paddle.Row + paddle.Height) < windowHeight
+	player.CurrentScore = r.server.finalScore
 }
 
-func isTouchTopBorder(paddle *Player) bool {
-	return paddle.Row > 0
+// clampPaddle 球拍不能移出場地
+func clampPaddle(paddle *Player) {
+	maxRow := float64(windowHeight - paddle.Height)
+	paddle.Row = math.Max(0, math.Min(maxRow, paddle.Row))
 }
@@ -0,0 +1,478 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+	"time"
+)
+
+const roomInboxSize = 64
+
+// BattleCountdown 兩位玩家都準備後，開始戰鬥前的倒數
+const BattleCountdown = 3000 * time.Millisecond
+
+// 送到房間 inbox 的事件，房間與房間中玩家的狀態只由房間 goroutine 修改
+// 除了以下事件，斷線(ConnBrokenMsg)與重連逾時(ReconnectTimeoutMsg)也會送到房間 inbox
+
+// playerMessage 房間中的玩家送來的 Client 訊息(e.g. LR, RS, BA, GB)
+type playerMessage struct {
+	player *Player
+	msg    Message
+}
+
+// joinRoom 玩家進入房間，人數已滿時由房間通知玩家，處理完後關閉 done
+type joinRoom struct {
+	player *Player
+	done   chan struct{}
+}
+
+// resumeRoom 斷線的玩家以新連線回到房間，回覆是否成功
+type resumeRoom struct {
+	player  *Player
+	session Session
+	welcome *WelcomeMsg
+	reply   chan bool
+}
+
+// newRoom 產生房間並將創建者加入房間，呼叫後需以 go room.run() 啟動房間 goroutine
+func newRoom(server *Server, roomId string, name string, creator *Player) *Room {
+	r := &Room{
+		RoomId:     roomId,
+		Name:       name,
+		RoomStatus: RoomStatusWaiting,
+		Creator:    creator,
+		CreateDate: time.Now().Format("2006-01-02 15:04"),
+		physics:    server.physicsFor(name),
+		server:     server,
+		inbox:      make(chan interface{}, roomInboxSize),
+		done:       make(chan struct{}),
+	}
+
+	// 將此創建房間的玩家加入到房間中
+	r.players = append(r.players, creator)
+	creator.SetScene(SceneRoom)
+	creator.setRoom(r)
+	return r
+}
+
+// run 房間 goroutine，處理 inbox 中的事件、開始前倒數與遊戲迴圈，房間沒有玩家或伺服器停止時結束
+func (r *Room) run() {
+	// 傳送房間資訊該房間創建者
+	r.notifyRoomPlayerUpdateRoomDetail()
+
+	//快速配對的房間建立時雙方都已準備，直接開始倒數
+	if r.isAllReady() {
+		r.prepareBattle()
+	}
+
+	//定期更新房間資訊，讓玩家看到彼此的延遲(戰鬥中由 BS 帶著)
+	latencyReport := time.NewTicker(PingInterval)
+	defer latencyReport.Stop()
+
+	for !isRoomEmpty(r) {
+		select {
+		case <-r.server.jobCtx.Done():
+			r.stop()
+			return
+
+		case event := <-r.inbox:
+			r.handleRoomEvent(event)
+
+		case <-r.countdown:
+			r.startBattle()
+
+		case now := <-r.tick:
+			r.updateBattle(now)
+
+		case <-r.abort:
+			r.abortBattle()
+
+		case <-latencyReport.C:
+			if len(r.players) == 2 && r.RoomStatus == RoomStatusWaiting {
+				r.notifyRoomPlayerUpdateRoomDetail()
+			}
+		}
+	}
+
+	//當房間沒人時 移除空房間，觀戰的玩家回到大廳
+	r.releaseSpectators()
+	r.stop()
+	r.server.toLobby(roomClosed{roomId: r.RoomId})
+}
+
+func (r *Room) handleRoomEvent(event interface{}) {
+	switch e := event.(type) {
+
+	case joinRoom:
+		r.enter(e.player)
+		close(e.done)
+
+	case spectateRoom:
+		r.watch(e.player, e.msg)
+		close(e.done)
+
+	case playerMessage:
+		r.handlePlayerMessage(e.player, e.msg)
+
+	case *ConnBrokenMsg:
+		r.handleConnBroken(e)
+
+	case *ReconnectTimeoutMsg:
+		r.handleReconnectTimeout(e)
+
+	case resumeRoom:
+		e.reply <- r.resumePlayer(e.player, e.session, e.welcome)
+	}
+}
+
+func (r *Room) handlePlayerMessage(player *Player, msg Message) {
+	server := r.server
+
+	//觀戰的玩家不能操作
+	if r.isSpectator(player.Id) {
+		r.handleSpectatorMessage(player, msg)
+		return
+	}
+
+	//已經不在房間中(e.g. 斷線後被移除)的玩家
+	if !r.hasPlayer(player.Id) {
+		server.sendError(player, ErrCodeNotInRoom, fmt.Sprintf("not in room %s", r.RoomId), msg)
+		return
+	}
+
+	switch player.Scene {
+	//房間中的操作(e.g.準備開始與離開房間)
+	case SceneRoom:
+		switch m := msg.(type) {
+		//離開房間
+		case *LeaveRoomMsg:
+			if !r.checkRoomId(player, m.RoomId, msg) {
+				break
+			}
+			r.leave(player)
+			break
+
+		//加入電腦玩家當對手
+		case *AddBotMsg:
+			if !r.checkRoomId(player, m.RoomId, msg) {
+				break
+			}
+			r.addBot(player, m)
+			break
+
+		//移除電腦玩家
+		case *RemoveBotMsg:
+			if !r.checkRoomId(player, m.RoomId, msg) {
+				break
+			}
+			r.removeBot()
+			break
+
+		//準備開始&取消準備
+		case *ReadyStartMsg:
+			if !r.checkRoomId(player, m.RoomId, msg) {
+				break
+			}
+
+			//伺服器關閉中不再開始新的戰鬥
+			if server.isShuttingDown() {
+				server.sendError(player, ErrCodeShuttingDown, "server shutting down", msg)
+				break
+			}
+
+			//更新準備狀態
+			r.updatePlayerReadyStatus(player.Id)
+			//通知房間玩家準備狀態
+			r.notifyRoomPlayerUpdateRoomDetail()
+
+			//檢查是否兩個都按開始了，若是就開始倒數
+			if r.isAllReady() && r.countdown == nil {
+				r.prepareBattle()
+			}
+			break
+
+		default:
+			server.sendWrongSceneError(player, msg)
+		}
+
+	case SceneBattle:
+		//戰鬥中的操作(e.g.移動與終止遊戲)
+		switch m := msg.(type) {
+
+		case *BattleActionMsg:
+			r.handleBattleAction(player, m.Action, m.Seq)
+			break
+
+		case *GiveUpBattleMsg:
+			if !r.checkRoomId(player, m.RoomId, msg) {
+				break
+			}
+			// 直接設置Loser
+			r.setLoser(player.Id, MatchEndSurrender)
+			server.logger.Info(fmt.Sprintf("玩家 %s 已經發起投降！", player.Id))
+			break
+
+		default:
+			server.sendWrongSceneError(player, msg)
+		}
+
+	default:
+		server.sendWrongSceneError(player, msg)
+	}
+}
+
+// checkRoomId 訊息中的房間 id 與玩家所在的房間不同時，回報錯誤並回傳 false
+func (r *Room) checkRoomId(player *Player, roomId string, msg Message) bool {
+	if roomId != r.RoomId {
+		r.server.sendError(player, ErrCodeNotInRoom, fmt.Sprintf("not in room %s", roomId), msg)
+		return false
+	}
+	return true
+}
+
+func (r *Room) enter(player *Player) {
+	if len(r.players) >= 2 {
+		//人數已滿 通知！
+		r.sendRoomFull(player, &EnterRoomMsg{RoomId: r.RoomId})
+		return
+	}
+
+	//修改Player Scene
+	player.SetScene(SceneRoom)
+	player.RoomReadyStatus = 0
+	r.players = append(r.players, player)
+	player.setRoom(r)
+
+	// 通知所有在『大廳』的玩家(更新房間人數)
+	r.updateLobby()
+
+	// 通知在『房間中』的玩家 Room
+	r.notifyRoomPlayerUpdateRoomDetail()
+
+	r.server.logger.Info(fmt.Sprintf(logger.PlayerEnterRoomMsg, player.Id, r.RoomId))
+}
+
+// sendRoomFull 回報房間人數已滿，舊版Client不認得錯誤訊息，只送 RF
+func (r *Room) sendRoomFull(player *Player, msg Message) {
+	if player.ProtocolVersion == LegacyProtocolVersion {
+		r.server.sendMsg(player, generateRoomsFullPayload(r.RoomId))
+		return
+	}
+	r.server.sendError(player, ErrCodeRoomFull, fmt.Sprintf("room %s is full", r.RoomId), msg)
+}
+
+func (r *Room) leave(player *Player) {
+	//移除Room中的此玩家
+	r.removeRoomPlayer(player.Id)
+
+	//更改玩家場景狀態
+	player.SetScene(SceneLobby)
+	player.RoomReadyStatus = 0
+	player.setRoom(nil)
+
+	//通知大廳玩家(更新房間List)
+	r.updateLobby()
+
+	//通知房間內剩下的玩家
+	r.notifyRoomPlayerUpdateRoomDetail()
+	r.server.logger.Info(fmt.Sprintf("%s 離開房間 Room id:%s", player.Id, r.RoomId))
+}
+
+// prepareBattle 通知玩家準備開始，倒數結束後開始戰鬥
+func (r *Room) prepareBattle() {
+	//伺服器關閉中不再開始新的戰鬥
+	if !r.server.battles.add() {
+		r.resetRoomStatus()
+		r.notifyRoomPlayerUpdateRoomDetail()
+		return
+	}
+
+	//通知玩家準備開始
+	r.notifyRoomPlayer(generateStartBattlePayload(r.RoomId))
+
+	r.countdown = time.After(BattleCountdown)
+	r.abort = r.server.battleCtx.Done()
+}
+
+// startBattle 倒數結束，倒數期間有玩家離開時取消這場戰鬥
+func (r *Room) startBattle() {
+	r.countdown = nil
+
+	if len(r.players) < 2 {
+		r.stopBattle()
+		r.resetRoomStatus()
+		r.notifyRoomPlayerUpdateRoomDetail()
+		return
+	}
+
+	r.updateRoomStatus(RoomStatusPlaying)
+	r.updateLobby()
+
+	r.startGame()
+}
+
+// finishBattle 戰鬥結束，更新雙方評分後玩家回到房間
+func (r *Room) finishBattle() {
+	r.stopBattle()
+
+	//投降與斷線未重連都是以 setLoser 判負，同樣計入評分與比賽紀錄
+	if over, winner := r.isGameOver(); over {
+		loser := r.players[0]
+		if loser == winner {
+			loser = r.players[1]
+		}
+		r.updateRatings(winner, loser)
+		r.recordMatch(winner)
+	}
+
+	r.updateRoomStatus(RoomStatusWaiting)
+	r.resetRoomStatus()
+
+	updateRoomPlayerScene(r, SceneRoom)
+
+	//未重連的玩家在戰鬥結束後移除
+	r.removeDisconnectedPlayers()
+
+	//通知玩家遊戲結束，觀戰的玩家收到 BO 後回到大廳
+	r.notifyRoomPlayerUpdateRoomDetail()
+	r.notifyRoomPlayerBattleOver()
+	r.releaseSpectators()
+	r.updateLobby()
+}
+
+// abortBattle 伺服器關閉期限已到，中止倒數或進行中的戰鬥，玩家與 finishBattle 相同回到房間
+func (r *Room) abortBattle() {
+	playing := r.RoomStatus == RoomStatusPlaying
+	if playing {
+		//已分出勝負(e.g. 剛投降)但還沒結算的戰鬥照常結束
+		if over, _ := r.isGameOver(); over {
+			r.finishBattle()
+			return
+		}
+
+		//未在期限內結束的戰鬥記錄為中止，不計入評分
+		player1 := r.players[0]
+		player2 := r.players[1]
+		r.server.logger.Warn(fmt.Sprintf(logger.BattleAbortedMsg, r.RoomId, player1.CurrentScore, player2.CurrentScore))
+		r.endReason = MatchEndAborted
+		r.recordMatch(nil)
+	}
+
+	r.countdown = nil
+	r.stopBattle()
+	r.updateRoomStatus(RoomStatusWaiting)
+	r.resetRoomStatus()
+
+	updateRoomPlayerScene(r, SceneRoom)
+
+	//未重連的玩家在戰鬥結束後移除
+	r.removeDisconnectedPlayers()
+
+	//通知玩家戰鬥已中止，觀戰的玩家回到大廳
+	r.notifyRoomPlayerUpdateRoomDetail()
+	if playing {
+		r.notifyRoomPlayerBattleOver()
+	}
+	r.releaseSpectators()
+	r.updateLobby()
+}
+
+// stopBattle 停止遊戲迴圈並結束這場戰鬥的記錄
+func (r *Room) stopBattle() {
+	if r.loop != nil {
+		r.logTickStats(time.Now())
+		r.loop.stop()
+		r.loop = nil
+		r.tick = nil
+	}
+	if r.abort != nil {
+		r.abort = nil
+		r.server.battles.done()
+	}
+}
+
+// stop 房間 goroutine 結束前停止所有計時，之後送到 inbox 的事件都會被忽略
+func (r *Room) stop() {
+	r.stopBattle()
+	for _, player := range r.players {
+		if player.reconnectTimer != nil {
+			player.reconnectTimer.Stop()
+		}
+	}
+	close(r.done)
+}
+
+// updateLobby 通知大廳此房間的最新狀態
+func (r *Room) updateLobby() {
+	r.server.toLobby(roomUpdated{info: r.info()})
+}
+
+func (r *Room) info() RoomInfo {
+	return RoomInfo{r.RoomId, r.Name, r.CreateDate, len(r.players), r.RoomStatus, len(r.spectators)}
+}
+
+func (r *Room) notifyRoomPlayerUpdateRoomDetail() {
+	detailPayload := generateRoomsDetailPayload(*r)
+	r.notifyRoomPlayer(detailPayload)
+}
+
+func (r *Room) notifyRoomPlayerBattleOver() {
+	detailPayload := generateBattleOver(r.RoomId)
+	r.notifyRoomPlayer(detailPayload)
+	r.notifySpectators(detailPayload)
+}
+
+// notifyBattlePlayer 通知房間中仍連線的戰鬥中玩家
+func (r *Room) notifyBattlePlayer(payload Message) {
+	for _, player := range r.players {
+		if player.Scene == SceneBattle && !player.Disconnected {
+			r.server.sendMsg(player, payload)
+		}
+	}
+}
+
+func (r *Room) notifyRoomPlayer(payload Message) {
+	for _, player := range r.players {
+		if player.Scene == SceneRoom {
+			r.server.sendMsg(player, payload)
+		}
+	}
+}
+
+// send 送出事件給房間 goroutine，房間已關閉時回傳 false
+func (r *Room) send(event interface{}) bool {
+	select {
+	case r.inbox <- event:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// join 請房間加入此玩家並等待處理完成，房間已關閉時回傳 false
+func (r *Room) join(player *Player) bool {
+	done := make(chan struct{})
+	if !r.send(joinRoom{player: player, done: done}) {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// resume 請房間讓斷線的玩家以新連線回到房間，回傳是否成功
+func (r *Room) resume(player *Player, session Session, welcome *WelcomeMsg) bool {
+	reply := make(chan bool, 1)
+	if !r.send(resumeRoom{player: player, session: session, welcome: welcome, reply: reply}) {
+		return false
+	}
+	select {
+	case ok := <-reply:
+		return ok
+	case <-r.done:
+		return false
+	}
+}
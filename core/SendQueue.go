@@ -0,0 +1,184 @@
+package core
+
+import (
+	"Pong/logger"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SendQueueSize 每條連線最多暫存的待送訊息數
+const SendQueueSize = 64
+
+// SlowClientTimeout 送出佇列滿載後超過此時間都沒有降到低水位(一半)以下的 Client 會被中止連線
+const SlowClientTimeout = 5 * time.Second
+
+// QueueReportInterval 回報各玩家送出佇列深度與延遲的間隔
+const QueueReportInterval = 10 * time.Second
+
+// ErrSendQueueFull 送出佇列已滿，訊息被丟棄
+var ErrSendQueueFull = errors.New("send queue full")
+
+// queuedSession 為每條連線提供獨立的寫入 goroutine，Send 只將訊息放入有上限的佇列，不會因為 Client 太慢而阻塞呼叫端
+// 佇列中的戰鬥狀態(BS)只保留最新的一筆
+type queuedSession struct {
+	Session
+
+	logger  Logger
+	size    int
+	timeout time.Duration
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	queue     []Message
+	peak      int       // 上次回報後的最高深度
+	fullSince time.Time // 佇列開始滿載的時間，降到低水位以下才重設為零值
+	closing   bool      // 已呼叫 Close，送完佇列後關閉連線
+	err       error     // 寫入失敗或已關閉後，Send 回傳此錯誤
+}
+
+// newQueuedSession 包裝 session 並啟動寫入 goroutine
+func newQueuedSession(session Session, size int, timeout time.Duration, l Logger) *queuedSession {
+	s := &queuedSession{Session: session, logger: l, size: size, timeout: timeout}
+	s.cond = sync.NewCond(&s.mutex)
+	go s.writeLoop()
+	return s
+}
+
+// Send 將訊息放入佇列，佇列已滿時丟棄訊息並回傳 ErrSendQueueFull
+func (s *queuedSession) Send(msg Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.closing {
+		return ErrSessionClosed
+	}
+
+	//舊的戰鬥狀態還沒送出，直接換成最新的
+	if msg.Type() == BattleSituationHeader {
+		for i, queued := range s.queue {
+			if queued.Type() == BattleSituationHeader {
+				s.queue[i] = msg
+				return nil
+			}
+		}
+	}
+
+	if len(s.queue) >= s.size {
+		if s.fullSince.IsZero() {
+			s.fullSince = time.Now()
+			time.AfterFunc(s.timeout, s.evictIfStalled)
+		}
+		return ErrSendQueueFull
+	}
+
+	s.queue = append(s.queue, msg)
+	if len(s.queue) > s.peak {
+		s.peak = len(s.queue)
+	}
+	s.cond.Signal()
+	return nil
+}
+
+// Close 送完佇列中的訊息後關閉連線，Client 沒有在 timeout 內讀取時直接關閉
+func (s *queuedSession) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing {
+		return nil
+	}
+	s.closing = true
+	s.cond.Signal()
+	time.AfterFunc(s.timeout, func() { s.Session.Close() })
+	return nil
+}
+
+// QueueDepth 目前佇列深度，與上次呼叫後的最高深度
+func (s *queuedSession) QueueDepth() (depth int, peak int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	depth, peak = len(s.queue), s.peak
+	s.peak = depth
+	return depth, peak
+}
+
+func (s *queuedSession) writeLoop() {
+	for {
+		s.mutex.Lock()
+		for len(s.queue) == 0 && !s.closing && s.err == nil {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 || s.err != nil {
+			s.mutex.Unlock()
+			s.Session.Close()
+			return
+		}
+		msg := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		//每送出一筆就重設的話，讀得很慢但沒有完全停止的 Client 永遠不會被中止
+		if len(s.queue) <= s.lowWater() {
+			s.fullSince = time.Time{}
+		}
+		s.mutex.Unlock()
+
+		err := s.Session.Send(msg)
+		if errors.Is(err, ErrNotSupportedByCodec) {
+			//此Client的編碼不支援這個訊息(e.g. 舊版Client)，直接略過
+			s.logger.Debug(fmt.Sprintf(logger.EncodeMsgSkippedMsg, s.RemoteAddr(), msg.Type(), err))
+			continue
+		}
+		if errors.Is(err, ErrMessageTooLarge) {
+			//無法編碼的訊息只略過，連線仍可繼續使用
+			s.logger.Warn(fmt.Sprintf(logger.EncodeMsgSkippedMsg, s.RemoteAddr(), msg.Type(), err))
+			continue
+		}
+		if err != nil {
+			s.fail(err)
+		}
+	}
+}
+
+// lowWater 佇列降到此深度以下才算不再滿載
+func (s *queuedSession) lowWater() int {
+	return s.size / 2
+}
+
+// evictIfStalled 佇列從滿載開始超過 timeout 都沒有降到低水位以下，中止此連線
+func (s *queuedSession) evictIfStalled() {
+	s.mutex.Lock()
+	stalled := !s.fullSince.IsZero() && time.Since(s.fullSince) >= s.timeout
+	s.mutex.Unlock()
+
+	if stalled {
+		s.logger.Warn(fmt.Sprintf(logger.SlowClientEvictedMsg, s.RemoteAddr(), s.timeout))
+		s.fail(ErrSendQueueFull)
+	}
+}
+
+// fail 丟棄佇列並立即關閉連線，之後的 Send 都回傳 err
+func (s *queuedSession) fail(err error) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.queue = nil
+	s.cond.Signal()
+	s.mutex.Unlock()
+
+	s.Session.Close()
+}
+
+// queueDepth 回傳連線的送出佇列深度，沒有送出佇列的連線回傳 0
+func queueDepth(session Session) (depth int, peak int) {
+	if queued, ok := session.(*queuedSession); ok {
+		return queued.QueueDepth()
+	}
+	return 0, 0
+}
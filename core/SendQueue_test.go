@@ -0,0 +1,202 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string) {}
+func (nopLogger) Info(string)  {}
+func (nopLogger) Warn(string)  {}
+func (nopLogger) Error(string) {}
+
+// gatedSession 模擬讀取很慢的 Client，每次 release 才完成一筆 Send
+type gatedSession struct {
+	*PipeSession
+
+	started chan Message  // writeLoop 開始送出的訊息
+	release chan struct{} // 允許完成一筆 Send
+
+	mutex sync.Mutex
+	sent  []Message
+}
+
+func newGatedSession() *gatedSession {
+	server, _ := NewSessionPipe("gated")
+	return &gatedSession{PipeSession: server, started: make(chan Message, 64), release: make(chan struct{})}
+}
+
+func (g *gatedSession) Send(msg Message) error {
+	g.started <- msg
+	select {
+	case <-g.release:
+	case <-g.closed:
+		return ErrSessionClosed
+	}
+	g.mutex.Lock()
+	g.sent = append(g.sent, msg)
+	g.mutex.Unlock()
+	return nil
+}
+
+func (g *gatedSession) sentMsgs() []Message {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]Message(nil), g.sent...)
+}
+
+func (g *gatedSession) waitStarted(t *testing.T) Message {
+	t.Helper()
+	select {
+	case msg := <-g.started:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("writeLoop did not send")
+		return nil
+	}
+}
+
+func (g *gatedSession) isClosed() bool {
+	select {
+	case <-g.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func battleSituation(tick int) Message {
+	return &BattleSituationMsg{Tick: tick}
+}
+
+func TestSendQueueCoalescesBattleSituation(t *testing.T) {
+	g := newGatedSession()
+	q := newQueuedSession(g, 4, time.Minute, nopLogger{})
+	defer q.fail(ErrSessionClosed)
+
+	//第一筆由 writeLoop 取出並卡在 Send，之後的訊息留在佇列中
+	q.Send(battleSituation(1))
+	g.waitStarted(t)
+
+	q.Send(&OnlinePlayerCountMsg{Count: 1})
+	q.Send(battleSituation(2))
+	q.Send(battleSituation(3))
+	q.Send(&OnlinePlayerCountMsg{Count: 2})
+	if depth, _ := q.QueueDepth(); depth != 3 {
+		t.Fatalf("queue depth = %d, want 3", depth)
+	}
+
+	for i := 0; i < 4; i++ {
+		if i > 0 {
+			g.waitStarted(t)
+		}
+		g.release <- struct{}{}
+	}
+
+	//最新的 BS 取代佇列中舊的 BS，其他訊息維持順序
+	want := []string{"BS1", "OC1", "BS3", "OC2"}
+	sent := g.sentMsgs()
+	for len(sent) < len(want) {
+		time.Sleep(time.Millisecond)
+		sent = g.sentMsgs()
+	}
+	for i, msg := range sent {
+		var got string
+		switch m := msg.(type) {
+		case *BattleSituationMsg:
+			got = fmt.Sprintf("BS%d", m.Tick)
+		case *OnlinePlayerCountMsg:
+			got = fmt.Sprintf("OC%d", m.Count)
+		}
+		if got != want[i] {
+			t.Errorf("sent[%d] = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestSendQueueEvictsStalledClient(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	g := newGatedSession()
+	q := newQueuedSession(g, 2, timeout, nopLogger{})
+
+	q.Send(&OnlinePlayerCountMsg{Count: 1})
+	g.waitStarted(t)
+	q.Send(&OnlinePlayerCountMsg{Count: 2})
+	q.Send(&OnlinePlayerCountMsg{Count: 3})
+	if err := q.Send(&OnlinePlayerCountMsg{Count: 4}); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("Send on full queue = %v, want %v", err, ErrSendQueueFull)
+	}
+
+	select {
+	case <-g.closed:
+	case <-time.After(10 * timeout):
+		t.Fatal("stalled client was not evicted")
+	}
+	if err := q.Send(&OnlinePlayerCountMsg{Count: 5}); !errors.Is(err, ErrSendQueueFull) {
+		t.Errorf("Send after eviction = %v, want %v", err, ErrSendQueueFull)
+	}
+}
+
+func TestSendQueueEvictsSlowClient(t *testing.T) {
+	//Client 持續讀取但跟不上，佇列一直在滿載附近，每送出一筆不會重新計時
+	const timeout = 200 * time.Millisecond
+	g := newGatedSession()
+	q := newQueuedSession(g, 4, timeout, nopLogger{})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-g.started:
+			case <-done:
+				return
+			}
+			select {
+			case g.release <- struct{}{}:
+			case <-g.closed:
+				return
+			}
+			time.Sleep(timeout / 8)
+		}
+	}()
+
+	deadline := time.Now().Add(10 * timeout)
+	for !g.isClosed() && time.Now().Before(deadline) {
+		q.Send(&OnlinePlayerCountMsg{})
+		time.Sleep(timeout / 40)
+	}
+	if !g.isClosed() {
+		t.Fatal("slow client was not evicted")
+	}
+}
+
+func TestSendQueueKeepsClientThatCatchesUp(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	g := newGatedSession()
+	q := newQueuedSession(g, 2, timeout, nopLogger{})
+	defer q.fail(ErrSessionClosed)
+
+	q.Send(&OnlinePlayerCountMsg{Count: 1})
+	g.waitStarted(t)
+	q.Send(&OnlinePlayerCountMsg{Count: 2})
+	q.Send(&OnlinePlayerCountMsg{Count: 3})
+	q.Send(&OnlinePlayerCountMsg{Count: 4})
+
+	//佇列清空後不再視為滿載
+	g.release <- struct{}{}
+	g.waitStarted(t)
+	g.release <- struct{}{}
+	g.waitStarted(t)
+	g.release <- struct{}{}
+
+	time.Sleep(2 * timeout)
+	if g.isClosed() {
+		t.Fatal("client that caught up was evicted")
+	}
+}
@@ -3,12 +3,18 @@ package core
 import (
 	"Pong/logger"
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"sync"
 	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
 )
 
 const SceneLobby = "Lobby"
@@ -18,505 +24,557 @@ const SceneBattle = "Battle"
 const ConnWorking = 1
 const ConnBroken = 0
 
-const MaxRoomCount = 100
+const MaxRoomCount = 100 // 預設的房間數量上限
 
-var RoomInitialId = 0
+// ErrServerClosed Shutdown 之後 Serve 回傳的錯誤
+var ErrServerClosed = errors.New("pong: server closed")
 
-var mutex sync.RWMutex
+// Server 一個獨立的 Pong 伺服器，大廳、房間與戰鬥都屬於各自的 Server，同一個程式中可以同時執行多個
+type Server struct {
+	addr         string        // ListenAndServe 監聽的 TCP 位址
+	wsAddr       string        // ListenAndServe 監聽的 WebSocket 位址，空字串代表不開啟
+	tickInterval time.Duration // 每一步模擬的間隔
+	sendInterval time.Duration // 傳送戰鬥狀態的間隔
+	maxRooms     int
+	finalScore   int
+	logger       Logger
 
-//大廳玩家的連線
-var lobbyPlayer = make(map[string]*Player)
+	physics     Physics                       // 房間預設的物理設定
+	roomPhysics func(roomName string) Physics // 依房間決定物理設定，nil 時使用 physics
 
-var lobbyRoom = make([]*Room, 0, MaxRoomCount)
+	handshakeTimeout time.Duration // 連線後需在此時間內送出 HI
+	heartbeatTimeout time.Duration // 超過此時間沒有收到玩家任何訊息即判定斷線
 
-// roomChanMsg Room跟main goroutine的溝通channel
-var roomChanMsg = make(chan string)
+	store *Store // 帳號資料庫，nil 時不啟用帳號
 
-func notifyLobbyPlayerUpdateRoomList() {
-	roomInfoPayload := generateRoomsListPayload()
-	notifyLobbyPlayer(roomInfoPayload)
-}
+	adminToken string // 管理介面的 Bearer token，空字串時不啟用管理介面
 
-func notifyRoomPlayerUpdateRoomDetail(room *Room) {
-	detailPayload := generateRoomsDetailPayload(*room)
-	notifyRoomPlayer(room, detailPayload)
-}
-
-func notifyRoomPlayerBattleOver(room *Room) {
-	detailPayload := generateBattleOver(room.RoomId)
-	notifyRoomPlayer(room, detailPayload)
-}
+	sendQueueSize     int           // 每條連線的送出佇列上限
+	slowClientTimeout time.Duration // 送出佇列滿載超過此時間的 Client 會被中止連線
 
-func listenPlayerOperation(connP *net.Conn, player *Player) {
-	conn := *connP
+	//以下大廳狀態只由大廳 goroutine(runLobby)存取，其他 goroutine 透過 lobbyInbox 送出事件
+	roomInitialId int
 
-	for {
-		payload, _ := bufio.NewReader(conn).ReadString('~')
+	//大廳玩家的連線
+	lobbyPlayer map[string]*Player
 
-		if payload == "" {
-			continue
-		}
+	lobbyRoom []*Room
+	roomInfo  map[string]RoomInfo // 各房間最後回報的狀態
 
-		header := string([]byte(payload)[0:2])
-		payload = removeHeaderTerminator(payload)
+	matchmaker matchmaker // 快速配對佇列
 
-		switch player.Scene {
-		//大廳中的操作(e.g.進入房間,創建房間與離開大廳)
-		case SceneLobby:
-
-			switch header {
-			//設玩家名字
-			case PlayerNameSetting:
-				playerId := conn.RemoteAddr().String()
-				playerName := parseSetPlayerName(payload)
-				player := lobbyPlayer[playerId]
-				player.NickName = playerName
-				break
+	// lobbyInbox 連線與房間 goroutine 送給大廳 goroutine 的事件
+	lobbyInbox chan interface{}
 
-			//創建房間
-			case CreateRoomHeader:
-				playerId := conn.RemoteAddr().String()
+	//背景工作(大廳、在線人數與延遲量測)在第一次 Serve 時啟動
+	jobsOnce sync.Once
+	jobCtx   context.Context
+	stopJobs context.CancelFunc
 
-				creator := lobbyPlayer[playerId]
-				roomName := parseCreateRoom(payload)
+	//戰鬥在 Shutdown 期限到時中止
+	battleCtx    context.Context
+	abortBattles context.CancelFunc
+	battles      *battleTracker
 
-				r := &Room{
-					RoomId:     generateRoomId(),
-					Name:       roomName,
-					RoomStatus: RoomStatusWaiting,
-					Creator:    creator,
-					CreateDate: time.Now().Format("2006-01-02 15:04"),
-				}
+	shuttingDown  int32
+	listenerMutex sync.Mutex
+	listeners     map[net.Listener]struct{}
+	httpServers   map[*http.Server]struct{}
+}
 
-				mutex.Lock()
-				// 將此創建房間的玩家加入到房間中
-				r.players = append(r.players, creator)
-				creator.SetScene(SceneRoom)
+// NewServer 以預設值產生 Server，再依序套用 opts
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		addr:         ":4321",
+		tickInterval: TickInterval,
+		sendInterval: SendInterval,
+		maxRooms:     MaxRoomCount,
+		finalScore:   FinalScore,
+		physics:      DefaultPhysics,
+		logger:       logger.Log,
+
+		handshakeTimeout: HandshakeTimeout,
+		heartbeatTimeout: HeartbeatTimeout,
+
+		sendQueueSize:     SendQueueSize,
+		slowClientTimeout: SlowClientTimeout,
+
+		lobbyPlayer: make(map[string]*Player),
+		roomInfo:    make(map[string]RoomInfo),
+		lobbyInbox:  make(chan interface{}, lobbyInboxSize),
+		battles:     &battleTracker{},
+		listeners:   make(map[net.Listener]struct{}),
+		httpServers: make(map[*http.Server]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.lobbyRoom = make([]*Room, 0, s.maxRooms)
 
-				lobbyRoom = append(lobbyRoom, r)
-				mutex.Unlock()
+	s.jobCtx, s.stopJobs = context.WithCancel(context.Background())
+	s.battleCtx, s.abortBattles = context.WithCancel(context.Background())
+	return s
+}
 
-				// 傳送房間資訊該房間創建者
-				notifyRoomPlayerUpdateRoomDetail(r)
+func (s *Server) notifyLobbyPlayerUpdateRoomList() {
+	roomInfoPayload := generateRoomsListPayload(s.getRoomList())
+	s.notifyLobbyPlayer(roomInfoPayload)
+}
 
-				logger.Log.Info(fmt.Sprintf("%s 創建新房間！", playerId))
+func (s *Server) listenPlayerOperation(session Session, player *Player) {
+	for {
+		//超過 heartbeatTimeout 沒有收到任何訊息(包含心跳封包)，判定該玩家已經斷線
+		session.SetReadDeadline(time.Now().Add(s.heartbeatTimeout))
+		msg, err := session.Receive()
+
+		if err != nil {
+			//連線已關閉、逾時或封包長度錯誤(無法再對齊下一個封包)，視為斷線
+			if isConnClosedErr(err) {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					s.logger.Warn(fmt.Sprintf(logger.HeartbeatTimeoutMsg, player.Id, time.Since(player.LastSeen()).Round(time.Millisecond)))
+				}
+				s.handlePlayerConnBroken(player, session)
+				return
+			}
+			s.logger.Warn(fmt.Sprintf(logger.DecodeFrameFailedMsg, session.RemoteAddr(), err))
+			s.sendDecodeError(player, err)
+			continue
+		}
+		player.touch()
 
-				//通知所有『在大廳』的玩家
-				notifyLobbyPlayerUpdateRoomList()
-				logger.Log.Info(fmt.Sprintf("玩家 %s 創建房間", playerId))
-				break
+		//接收Client心跳與延遲量測封包，任何場景都允許
+		if s.handleHeartBeatPayload(msg, player) || s.handlePongPayload(msg, player) {
+			continue
+		}
 
-			//進入房間
-			case EnterRoomHeader:
-				//把玩家加到房間資訊中(更新房間資訊) 並更改玩家場景
-				playerId := conn.RemoteAddr().String()
-				roomId := parseEnterRoom(payload)
+		//房間中與戰鬥中的操作(e.g.準備開始、離開房間、移動與終止遊戲)交給房間 goroutine 處理
+		if room := player.currentRoom(); room != nil {
+			if !room.send(playerMessage{player: player, msg: msg}) {
+				s.sendError(player, ErrCodeUnknownRoom, fmt.Sprintf("room %s not found", room.RoomId), msg)
+			}
+			continue
+		}
 
-				player := lobbyPlayer[playerId]
-				room := findRoomById(roomId)
+		//大廳中的操作(e.g.進入房間,創建房間與離開大廳)
+		switch m := msg.(type) {
+		//設玩家名字
+		case *PlayerNameMsg:
+			s.setNickName(player, m)
+			break
 
-				if len(room.players) >= 2 {
-					//人數已滿 通知！
-					payload := generateRoomsFullPayload(roomId)
-					sendMsg(player, payload)
-					break
-				}
+		//註冊帳號並登入
+		case *RegisterMsg:
+			s.register(player, m)
+			break
 
-				//修改Player Scene
-				mutex.Lock()
-				player.SetScene(SceneRoom)
-				room.players = append(room.players, player)
-				mutex.Unlock()
+		//登入帳號
+		case *LoginMsg:
+			s.login(player, m)
+			break
 
-				// 通知所有在『大廳』的玩家(更新房間人數)
-				notifyLobbyPlayerUpdateRoomList()
+		//查詢最近的比賽紀錄
+		case *MatchHistoryMsg:
+			s.sendMatchHistory(player, m)
+			break
 
-				// 通知在『房間中』的玩家 Room (如果房間本身沒人 則不會通知)
-				notifyRoomPlayerUpdateRoomDetail(room)
+		//創建房間
+		case *CreateRoomMsg:
+			playerId := player.Id
 
-				logger.Log.Info(fmt.Sprintf(logger.PlayerEnterRoomMsg, playerId, roomId))
+			if s.rejectWhileMatching(player, msg) {
+				break
+			}
+			if utf8.RuneCountInString(m.RoomName) > MaxRoomNameLength {
+				s.sendError(player, ErrCodeBadRoomName, "room name too long", msg)
 				break
-			//離開大廳
-			case LeaveLobby:
-				playerId := conn.RemoteAddr().String()
+			}
 
-				//通知玩家已成功離開大廳
-				player := lobbyPlayer[playerId]
-				payload := generateLeaveLobbySuccessPayload()
-				sendMsg(player, payload)
+			room := s.createRoom(player, m.RoomName)
+			if room == nil {
+				s.sendError(player, ErrCodeServerFull, "room limit reached", msg)
+				break
+			}
 
-				mutex.Lock()
-				//關閉連線
-				disconnectPlayerConn(playerId)
-				delete(lobbyPlayer, playerId)
-				mutex.Unlock()
+			s.logger.Info(fmt.Sprintf("玩家 %s 創建房間", playerId))
+			break
 
-				logger.Log.Info(fmt.Sprintf("%s 離開大廳！", playerId))
-				logger.Log.Info(fmt.Sprintf("當下人數：%d", len(lobbyPlayer)))
+		//進入房間
+		case *EnterRoomMsg:
+			//把玩家加到房間資訊中(更新房間資訊) 並更改玩家場景，人數已滿時由房間通知玩家
+			roomId := m.RoomId
 
-				notifyLobbyPlayerUpdateRoomList()
+			if s.rejectWhileMatching(player, msg) {
 				break
 			}
+			room := s.findRoom(roomId)
+			if room == nil || !room.join(player) {
+				s.sendError(player, ErrCodeUnknownRoom, fmt.Sprintf("room %s not found", roomId), msg)
+			}
 			break
 
-		//房間中的操作(e.g.準備開始與離開房間)
-		case SceneRoom:
-			switch header {
-			//離開房間
-			case LeaveRoomHeader:
-				roomId := parseLeaveRoom(payload)
-				room := findRoomById(roomId)
-				playerId := conn.RemoteAddr().String()
-
-				mutex.Lock()
-				//移除Room中的此玩家
-				removeRoomPlayer(room, playerId)
-
-				//當房間沒人時 移除空房間
-				if isRoomEmpty(room) {
-					roomIndex := getRoomIndex(room)
-					lobbyRoom = append(lobbyRoom[:roomIndex], lobbyRoom[roomIndex+1:]...)
-				}
-				//更改玩家場景狀態
-				player.SetScene(SceneLobby)
-				mutex.Unlock()
-
-				//通知大廳玩家(更新房間List)
-				notifyLobbyPlayerUpdateRoomList()
+		//觀戰(只能觀看戰鬥中的房間)
+		case *WatchRoomMsg:
+			roomId := m.RoomId
 
-				//通知房間內剩下的玩家
-				notifyRoomPlayerUpdateRoomDetail(room)
-				logger.Log.Info(fmt.Sprintf("%s 離開房間 Room id:%s", playerId, roomId))
+			if s.rejectWhileMatching(player, msg) {
 				break
+			}
+			room := s.findRoom(roomId)
+			if room == nil || !room.spectate(player, msg) {
+				s.sendError(player, ErrCodeUnknownRoom, fmt.Sprintf("room %s not found", roomId), msg)
+			}
+			break
 
-			//準備開始&取消準備
-			case ReadyStartHeader:
-				//(更新房間資訊) 並更改玩家準備狀態(RoomReadyStatus)
-				roomId := parseReadyStart(payload)
-
-				room := findRoomById(roomId)
-				playerId := conn.RemoteAddr().String()
-
-				mutex.Lock()
-				//更新準備狀態
-				room.updatePlayerReadyStatus(playerId)
-				mutex.Unlock()
-
-				//檢查是否兩個都按開始了，若是就開始了
-				if len(room.players) == 2 {
-					player1 := room.players[0]
-					player2 := room.players[1]
-
-					if player1.RoomReadyStatus == 1 && player2.RoomReadyStatus == 1 {
-						roomChanMsg <- generateStartBattlePayload(roomId)
-					}
-				}
-				//通知房間玩家準備開始戰鬥
-				go notifyRoomPlayerUpdateRoomDetail(room)
-				break
+		//快速配對，已在排隊中時忽略
+		case *QuickMatchMsg:
+			if player.startMatching() {
+				s.toLobby(joinQueue{player: player})
 			}
 			break
 
-		case SceneBattle:
-			//戰鬥中的操作(e.g.移動與終止遊戲)
-			switch header {
+		//取消快速配對
+		case *QuickMatchCancelMsg:
+			s.toLobby(leaveQueue{player: player})
+			break
 
-			case BattleActionHeader:
-				battleAction := parsePlayerBattleAction(payload)
-				handleBattleOperation(battleAction, player)
-				break
+		//離開大廳
+		case *LeaveLobbyMsg:
+			s.toLobby(leaveLobby{player: player})
+			//連線由大廳關閉，不再監聽
+			return
 
-			case GiveUpBattleHeader:
-				roomId, _ := parseInterruptBattle(payload)
-				playerId := conn.RemoteAddr().String()
-				roomChanMsg <- generateOpponentGiveUpBattle(roomId, playerId)
-				break
+		default:
+			//排隊中的玩家的場景會被大廳改變，不在此讀取
+			if !s.rejectWhileMatching(player, msg) {
+				s.sendWrongSceneError(player, msg)
 			}
-			time.Sleep(10 * time.Millisecond)
-			break
 		}
+	}
+}
+
+// handlePlayerConnBroken 在房間中的玩家交給房間處理(戰鬥中的玩家會等待重連)，其餘由大廳移除
+func (s *Server) handlePlayerConnBroken(player *Player, session Session) {
+	msg := generateConnBrokenPayload(player.Id, session)
+	if room := player.currentRoom(); room != nil && room.send(msg) {
+		return
+	}
+	s.toLobby(msg)
+}
 
-		//接收Client心跳封包
-		handleHeartBeatPayload(header, conn)
+func (s *Server) sendError(player *Player, code int, reason string, request Message) {
+	var header MsgType
+	if request != nil {
+		header = request.Type()
+	}
+	s.sendMsg(player, generateErrorPayload(code, reason, header))
+}
 
-		time.Sleep(10 * time.Millisecond)
+// sendWrongSceneError 心跳封包在任何場景都允許，不需回報
+func (s *Server) sendWrongSceneError(player *Player, msg Message) {
+	if msg.Type() == HeartBeatHeader {
+		return
 	}
+	s.sendError(player, ErrCodeWrongScene, fmt.Sprintf("%s not allowed in scene %s", msg.Type(), player.Scene), msg)
 }
 
-func handleHeartBeatPayload(header string, conn net.Conn) {
-	//客戶端傳來的心跳封包 將count歸0
-	if header == HeartBeatHeader {
-		playerId := conn.RemoteAddr().String()
-		player := lobbyPlayer[playerId]
-		player.resetHeartbeat()
+// sendDecodeError 回報無法解析的封包
+func (s *Server) sendDecodeError(player *Player, err error) {
+	var header MsgType
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		header = decodeErr.MsgType
+	}
 
-		fmt.Println(fmt.Sprintf("連線仍然存活！玩家 %s 心跳計數歸零", playerId))
+	code := ErrCodeMalformedPayload
+	if errors.Is(err, ErrUnknownMsgType) {
+		code = ErrCodeUnknownMessage
 	}
+	s.sendMsg(player, generateErrorPayload(code, err.Error(), header))
 }
 
-func isRoomEmpty(room *Room) bool {
-	return len(room.players) == 0
+// isConnClosedErr 判斷讀取錯誤是否代表此連線已無法繼續使用
+// 只有內容無法解析(DecodeError)時連線仍對齊在下一個訊息，其餘(EOF、逾時、封包長度錯誤...)都視為斷線
+func isConnClosedErr(err error) bool {
+	var decodeErr *DecodeError
+	return !errors.As(err, &decodeErr)
 }
 
-func getRoomIndex(room *Room) int {
-	var index int
-	for i, r := range lobbyRoom {
-		if r.RoomId == room.RoomId {
-			index = i
-			break
+// handleHeartBeatPayload 是心跳封包時回傳 true，最後活動時間已在收到時更新
+func (s *Server) handleHeartBeatPayload(msg Message, player *Player) bool {
+	if msg.Type() == HeartBeatHeader {
+		s.logger.Debug(fmt.Sprintf(logger.HeartBeatMsg, player.Id))
+		return true
+	}
+	return false
+}
+
+// isRoomEmpty 房間中只剩電腦玩家時也視為空房間
+func isRoomEmpty(room *Room) bool {
+	for _, player := range room.players {
+		if !player.isBot() {
+			return false
 		}
 	}
-	return index
+	return true
 }
 
-func sendMsg(player *Player, payload string) int {
-	conn := *player.Conn
-	playerId := conn.RemoteAddr().String()
-	logger.Log.Info(fmt.Sprintf(logger.SendMsgContentMsg, conn.RemoteAddr().String(), payload))
-	_, err := conn.Write([]byte(payload))
+// sendMsg 將訊息放入玩家連線的送出佇列，實際寫入由該連線的寫入 goroutine 負責
+func (s *Server) sendMsg(player *Player, msg Message) int {
+	playerId := player.Id
+	session := player.Session()
+	s.logger.Info(fmt.Sprintf(logger.SendMsgContentMsg, session.RemoteAddr(), msgContent(msg)))
+
+	err := session.Send(msg)
+	if errors.Is(err, ErrSendQueueFull) {
+		//Client太慢，丟棄此訊息，持續滿載時由送出佇列中止連線
+		s.logger.Warn(fmt.Sprintf(logger.SendQueueFullMsg, playerId, msg.Type()))
+		return ConnWorking
+	}
 
-	//斷線了 傳訊息給main goroutine
+	//斷線了 關閉連線，由此玩家的連線 goroutine 讀取失敗後處理斷線
 	if err != nil {
-		//CB mean connection broken
-		roomChanMsg <- generateConnBrokenPayload(playerId)
-		logger.Log.Error(logger.ConnBrokenMsg + " => " + fmt.Sprintf("%s_%s", ConnBrokenHeader, logger.ConnBrokenMsg))
+		session.Close()
+		s.logger.Error(logger.ConnBrokenMsg + " => " + fmt.Sprintf("%s_%s", ConnBrokenHeader, logger.ConnBrokenMsg))
 		return ConnBroken
 	}
 	return ConnWorking
 }
 
-func sendGameState(connP *net.Conn, room *Room) int {
-	conn := *connP
-	player1 := room.players[0]
-	player2 := room.players[1]
-	ball := room.Ball
-
-	ballX, ballY := ball.Col, ball.Row
-	player1X, player1Y, player1Score := player1.Col, player1.Row, player1.CurrentScore
-	player2X, player2Y, player2Score := player2.Col, player2.Row, player2.CurrentScore
-
-	payload := generateBattlePayload(ballX, ballY,
-		player1X, player1Y, player1Score, player2X, player2Y, player2Score)
+func (s *Server) sendGameState(player *Player, room *Room) int {
+	payload := generateBattlePayload(room)
+	session := player.Session()
 
-	_, err := conn.Write([]byte(payload))
+	//佇列中尚未送出的舊狀態會被這次的狀態取代
+	err := session.Send(payload)
+	if errors.Is(err, ErrSendQueueFull) {
+		return ConnWorking
+	}
 
-	//斷線了 通知main goroutine
+	//斷線了 關閉連線，由此玩家的連線 goroutine 讀取失敗後處理斷線
 	if err != nil {
-		//CB mean connection broken
-		roomChanMsg <- generateConnBrokenPayload(conn.RemoteAddr().String())
+		session.Close()
 		return ConnBroken
 	}
 	return ConnWorking
 }
 
-func handleBattleOperation(userCommand string, player *Player) {
-	if userCommand == "" {
-		return
-	}
+// StartService 依 properties 設定啟動伺服器，直到 ctx 被取消後關閉伺服器
+func StartService(ctx context.Context) error {
+	env := os.Getenv("PONG_ENV")
+	host, port, wsPort := ReadProperties(env)
 
-	switch userCommand {
-	case "U":
-		if isTouchTopBorder(player) {
-			player.MoveUp()
+	opts := []Option{WithListenAddr(fmt.Sprintf("%s:%s", host, port))}
+	//瀏覽器Client使用WebSocket連線
+	if wsPort != "" {
+		opts = append(opts, WithWebSocketAddr(fmt.Sprintf("%s:%s", host, wsPort)))
+	}
+	handshakeTimeout, heartbeatTimeout := ReadTimeouts()
+	if handshakeTimeout > 0 {
+		opts = append(opts, WithHandshakeTimeout(handshakeTimeout))
+	}
+	if heartbeatTimeout > 0 {
+		opts = append(opts, WithHeartbeatTimeout(heartbeatTimeout))
+	}
+	tickRate, sendRate := ReadTickRates()
+	if tickRate > 0 {
+		opts = append(opts, WithTickInterval(time.Second/time.Duration(tickRate)))
+	}
+	if sendRate > 0 {
+		opts = append(opts, WithSendInterval(time.Second/time.Duration(sendRate)))
+	}
+	//帳號資料庫在伺服器關閉後才關閉
+	if storePath := ReadStorePath(); storePath != "" {
+		store, err := OpenStore(storePath)
+		if err != nil {
+			return err
 		}
-		break
+		defer store.Close()
+		opts = append(opts, WithStore(store))
+	}
+	if adminToken := ReadAdminToken(); adminToken != "" {
+		opts = append(opts, WithAdminToken(adminToken))
+	}
+	server := NewServer(opts...)
 
-	case "D":
-		if isTouchBottomBorder(player) {
-			player.MoveDown()
-		}
-		break
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownGracePeriod)
+	defer cancel()
+	//逾時中止的戰鬥已記錄在 Log 中
+	server.Shutdown(shutdownCtx)
+
+	logger.Log.Info(logger.ServerStoppedMsg)
+	return nil
 }
 
-func StartService() {
-	env := os.Getenv("PONG_ENV")
-	host, port := ReadProperties(env)
+// ListenAndServe 監聽設定的 TCP 與 WebSocket 位址並開始接受玩家連線，Shutdown 後回傳 ErrServerClosed
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp4", s.addr)
+	if err != nil {
+		return err
+	}
 
-	tcpAddr, _ := net.ResolveTCPAddr("tcp4", fmt.Sprintf("%s:%s", host, port))
-	listener, _ := net.ListenTCP("tcp", tcpAddr)
+	if s.wsAddr != "" {
+		wsListener, err := net.Listen("tcp", s.wsAddr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		go s.ServeWebSocket(wsListener)
+	}
 
-	go listenRoomChannel()
+	return s.Serve(listener)
+}
 
-	//心跳封包機制
-	go heartBeatJob()
+// Serve 在 listener 上接受 TCP 玩家連線，Shutdown 後回傳 ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	if !s.trackListener(listener) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.startJobs()
 
 	for {
-		logger.Log.Info("等待新玩家連線...")
+		s.logger.Info("等待新玩家連線...")
 
-		conn, _ := listener.Accept()
-		playerId := conn.RemoteAddr().String()
-		logger.Log.Info(fmt.Sprintf("Player已連線 (ip:%s)", playerId))
-
-		if len(lobbyRoom) >= MaxRoomCount {
-			//sent message to client say full room
-			//close connection
-			conn.Close()
-			logger.Log.Info(fmt.Sprintf("連線已滿，關閉ip:%s 的連線", playerId))
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isShuttingDown() {
+				return ErrServerClosed
+			}
+			s.logger.Error(fmt.Sprintf(logger.AcceptFailedMsg, err))
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
+		s.logger.Info(fmt.Sprintf("Player已連線 (ip:%s)", conn.RemoteAddr().String()))
 
-		//產生玩家
-		player := generatePlayer(playerId, &conn)
-
-		if lobbyPlayer[playerId] == nil {
-			lobbyPlayer[playerId] = player
-			//通知所有玩家 有新玩家家加入
-			go notifyOnlinePlayerCount()
-			logger.Log.Info(fmt.Sprintf("Player ip:%s 進入大廳", playerId))
-		}
-
-		//開始監聽玩家操作事件
-		go listenPlayerOperation(&conn, player)
-
-		roomInfoPayload := generateRoomsListPayload()
-		//傳送遊戲大廳給此新玩家
-		sendMsg(player, roomInfoPayload)
+		//握手完成前不會進入大廳，避免阻塞等待下一個連線
+		go s.acceptPlayer(conn)
 
 		time.Sleep(10 * time.Millisecond)
 	}
 }
 
-func notifyOnlinePlayerCount() {
-	for {
-		onlinePlayerCount := len(lobbyPlayer)
-		payload := generateOnlinePlayerCountPayload(onlinePlayerCount)
-		// 在大廳才傳送
-		notifyLobbyPlayer(payload)
-		time.Sleep(2000 * time.Millisecond)
+// AcceptSession 讓已建立的連線(e.g. NewSessionPipe)加入此伺服器，握手與遊戲流程與 TCP 玩家相同
+func (s *Server) AcceptSession(session Session) {
+	if s.isShuttingDown() {
+		session.Close()
+		return
 	}
+	s.startJobs()
+	s.acceptSession(session)
 }
 
-//通知所有『在大廳』的玩家
-func notifyLobbyPlayer(roomInfoPayload string) {
-	for _, player := range lobbyPlayer {
-		if player.Scene == SceneLobby {
-			sendMsg(player, roomInfoPayload)
-		}
-	}
+// startJobs 啟動大廳 goroutine(包含心跳與在線人數)，只會執行一次
+func (s *Server) startJobs() {
+	s.jobsOnce.Do(func() {
+		go s.runLobby()
+	})
 }
 
-func notifyRoomPlayer(room *Room, payload string) {
-	for _, player := range room.players {
-		if player.Scene == SceneRoom {
-			sendMsg(player, payload)
-		}
+// trackListener 記錄 listener 讓 Shutdown 可以關閉，已關閉時回傳 false
+func (s *Server) trackListener(listener net.Listener) bool {
+	s.listenerMutex.Lock()
+	defer s.listenerMutex.Unlock()
+
+	if s.isShuttingDown() {
+		return false
 	}
+	s.listeners[listener] = struct{}{}
+	return true
 }
 
-func listenRoomChannel() {
-	for {
-		select {
-		case msg := <-roomChanMsg:
-
-			header := string([]byte(msg)[0:2])
-
-			switch header {
-
-			case ConnBrokenHeader:
-				msg = removeHeaderTerminator(msg)
-				//斷線者的ip
-				connBrokenIp := msg
-
-				//斷線處理機制
-				connBrokenHandle(connBrokenIp)
-
-				//處理完後，通知所有玩家，更新大廳與房間資訊
-				roomsInfo := generateRoomsListPayload()
-				notifyLobbyPlayer(roomsInfo)
-				logger.Log.Info(logger.NotifyLobbyConnBrokenMsg)
-				break
-
-			case StartBattleHeader:
-				payload := removeHeaderTerminator(msg) //移除Header與終止符
-				room := findRoomById(payload)
-
-				//通知玩家準備開始
-				notifyRoomPlayer(room, msg)
-
-				//倒數三秒
-				time.Sleep(3000 * time.Millisecond)
-				room.updateRoomStatus(RoomStatusPlaying)
-				notifyLobbyPlayerUpdateRoomList()
-
-				go room.startGame()
-				break
-
-			case GiveUpBattleHeader:
-				payload := removeHeaderTerminator(msg) //移除Header與終止符
-				roomId, playerId := parseInterruptBattle(payload)
+// acceptPlayer 與新連線握手，成功後才將玩家加入大廳
+func (s *Server) acceptPlayer(conn net.Conn) {
+	reader := bufio.NewReader(conn)
 
-				room := findRoomById(roomId)
+	//依Client送來的第一個位元組決定編碼
+	codec := detectCodec(conn, reader)
 
-				// 直接設置Loser
-				room.setLoser(playerId)
-				logger.Log.Info(fmt.Sprintf("玩家 %s 已經發起投降！", playerId))
-				break
-
-			case BattleOverHeader:
+	s.acceptSession(newTCPSession(conn, reader, codec))
+}
 
-				roomId := parseBattleOver(msg)
-				room := findRoomById(roomId)
-				room.resetRoomStatus()
+// acceptSession 與新連線握手，成功後才將玩家加入大廳
+func (s *Server) acceptSession(session Session) {
+	//之後所有送給此連線的訊息都由它自己的寫入 goroutine 送出
+	session = newQueuedSession(session, s.sendQueueSize, s.slowClientTimeout, s.logger)
 
-				updateRoomPlayerScene(room, SceneRoom)
+	//產生玩家
+	player := generatePlayer(session)
 
-				//通知玩家遊戲結束
-				notifyRoomPlayerUpdateRoomDetail(room)
-				notifyRoomPlayerBattleOver(room)
+	if session.Codec().Name() == CodecLegacy {
+		//舊版Client不會握手
+		if s.admitPlayer() != 0 {
+			session.Close()
+			s.logger.Info(fmt.Sprintf("連線已滿，關閉ip:%s 的連線", session.RemoteAddr()))
+			return
+		}
+		player.ProtocolVersion = LegacyProtocolVersion
+	} else {
+		hello, welcome, reject := s.handshake(session)
+
+		//帶著 SessionToken 的連線為戰鬥中斷線後的重連
+		if reject == nil && hello.SessionToken != "" {
+			if s.resumePlayer(hello.SessionToken, session, welcome) {
+				return
 			}
-
+			reject = generateHelloRejectPayload(RejectSessionExpired, "session expired")
 		}
-	}
-}
 
-func heartBeatJob() {
-	//when count < 5，count += 1
-	//whe count >= 5，說明已超過15秒未收到該玩家心跳封包，則判定該玩家已經斷線；
-	for {
-		for _, player := range lobbyPlayer {
-			if player.HearBeatCount < 5 {
-				fmt.Println(fmt.Sprintf("玩家 %s 心跳跳一下, 當前倒數心跳:%d", player.IdAkaIpAddress, player.HearBeatCount))
-				player.Heartbeat()
-			}
-			if player.HearBeatCount >= 5 {
-				//斷線處理
-				fmt.Println(fmt.Sprintf("玩家%s斷線了", player.IdAkaIpAddress))
-				connBrokenHandle(player.IdAkaIpAddress)
-				//移除斷線者房間
-				room := findPlayerRoom(player.IdAkaIpAddress)
-				if room != nil {
-					removeRoom(lobbyRoom, room.RoomId)
-					logger.Log.Info(fmt.Sprintf("移除斷線者房間 名稱：%s, id: %s", room.Name, room.RoomId))
-				}
+		if reject == nil {
+			switch s.admitPlayer() {
+			case RejectServerFull:
+				reject = generateHelloRejectPayload(RejectServerFull, "server is full")
+			case RejectShuttingDown:
+				reject = generateHelloRejectPayload(RejectShuttingDown, "server shutting down")
 			}
 		}
-		time.Sleep(3000 * time.Millisecond)
-	}
 
-}
+		if reject != nil {
+			//通知Client被拒絕的原因後關閉連線
+			session.Send(reject)
+			session.Close()
+			s.logger.Info(fmt.Sprintf(logger.HandshakeRejectedMsg, session.RemoteAddr(), reject.Reason))
+			return
+		}
 
-func findRoomById(id string) *Room {
-	var targetIndex int
-	for i, room := range lobbyRoom {
-		if room.RoomId == id {
-			targetIndex = i
-			break
+		//玩家 id 與憑證只在握手成功時交給本人
+		welcome.PlayerId = player.Id
+		welcome.SessionToken = player.SessionToken
+		welcome.Scene = player.Scene
+		if s.sendMsg(player, welcome) == ConnBroken {
+			return
 		}
+		player.ProtocolVersion = welcome.Version
+		player.Features = welcome.Features
 	}
-	return lobbyRoom[targetIndex]
+
+	//進入大廳後由大廳傳送房間列表給此新玩家
+	if !s.toLobby(addPlayer{player: player}) {
+		session.Close()
+		return
+	}
+
+	//開始監聽玩家操作事件
+	go s.listenPlayerOperation(session, player)
 }
 
-func findPlayerRoom(playerId string) *Room {
-	var targetRoom *Room
-	for i, room := range lobbyRoom {
-		for _, player := range room.players {
-			if player.IdAkaIpAddress == playerId {
-				targetRoom = lobbyRoom[i]
-			}
+//通知所有『在大廳』的玩家
+func (s *Server) notifyLobbyPlayer(roomInfoPayload Message) {
+	for _, player := range s.lobbyPlayer {
+		if player.currentRoom() == nil {
+			s.sendMsg(player, roomInfoPayload)
 		}
 	}
-	return targetRoom
 }
 
 func removeRoom(rooms []*Room, roomId string) []*Room {
@@ -536,66 +594,40 @@ func updateRoomPlayerScene(room *Room, scene string) {
 	}
 }
 
-func removeRoomPlayer(room *Room, playerId string) {
-	var index int
-	for i, player := range room.players {
-		if player.IdAkaIpAddress == playerId {
-			index = i
-			break
-		}
-	}
-	room.players = append(room.players[:index], room.players[index+1:]...)
-}
-
-func generatePlayer(ip string, conn *net.Conn) *Player {
-	return &Player{
-		NickName:       "Player",
-		IdAkaIpAddress: ip,
-		Conn:           conn,
-		Scene:          SceneLobby,
+func generatePlayer(session Session) *Player {
+	player := &Player{
+		NickName:     "Player",
+		Id:           uuid.NewString(),
+		SessionToken: uuid.NewString(),
+		Scene:        SceneLobby,
+		Rating:       DefaultRating,
+		session:      session,
 	}
+	player.touch()
+	return player
 }
 
 type RoomInfo struct {
-	roomId      string
-	roomName    string
-	createDate  string
-	playerCount int
-	RoomStatus  int
+	RoomId         string `json:"roomId"`
+	RoomName       string `json:"roomName"`
+	CreateDate     string `json:"createDate"`
+	PlayerCount    int    `json:"playerCount"`
+	RoomStatus     int    `json:"roomStatus"`
+	SpectatorCount int    `json:"spectatorCount"` // 觀戰人數
 }
 
-func getRoomList() []RoomInfo {
+// getRoomList 依創建順序列出各房間最後回報的狀態
+func (s *Server) getRoomList() []RoomInfo {
 	var roomInfoSlice = make([]RoomInfo, 0, 10)
 
-	for i := 0; i < len(lobbyRoom); i++ {
-		roomId := lobbyRoom[i].RoomId
-		roomName := lobbyRoom[i].Name
-		createDate := lobbyRoom[i].CreateDate
-		playerCount := len(lobbyRoom[i].players)
-		roomStatus := lobbyRoom[i].RoomStatus
-
-		roomInfoSlice = append(roomInfoSlice, RoomInfo{roomId, roomName,
-			createDate, playerCount, roomStatus})
+	for _, room := range s.lobbyRoom {
+		roomInfoSlice = append(roomInfoSlice, s.roomInfo[room.RoomId])
 	}
 
 	return roomInfoSlice
 }
 
-func connBrokenHandle(connBrokenIp string) {
-	mutex.Lock()
-	//關閉連線
-	disconnectPlayerConn(connBrokenIp)
-	delete(lobbyPlayer, connBrokenIp)
-	mutex.Unlock()
-
-	logger.Log.Error(fmt.Sprintf("玩家 %s 連線異常，中止連線", connBrokenIp))
-}
-
-func generateRoomId() string {
-	RoomInitialId += 1
-	return strconv.Itoa(RoomInitialId)
-}
-
-func disconnectPlayerConn(playerId string) {
-	(*lobbyPlayer[playerId].Conn).Close()
+func (s *Server) generateRoomId() string {
+	s.roomInitialId += 1
+	return strconv.Itoa(s.roomInitialId)
 }
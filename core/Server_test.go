@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// pipeClient 以 PipeSession 模擬的 Client，不經過 Socket 直接與大廳、房間 goroutine 互動
+type pipeClient struct {
+	t       *testing.T
+	session *PipeSession
+}
+
+// connectPipe 連線並完成握手，回傳 Client 與 Server 給的 Welcome
+func connectPipe(t *testing.T, s *Server, name string) (*pipeClient, *WelcomeMsg) {
+	t.Helper()
+	server, client := NewSessionPipe(name)
+	go s.AcceptSession(server)
+
+	c := &pipeClient{t: t, session: client}
+	c.send(&HelloMsg{Version: ProtocolVersion})
+	welcome := c.expect("WE", func(msg Message) bool {
+		_, ok := msg.(*WelcomeMsg)
+		return ok
+	}).(*WelcomeMsg)
+	return c, welcome
+}
+
+func (c *pipeClient) send(msg Message) {
+	c.t.Helper()
+	if err := c.session.Send(msg); err != nil {
+		c.t.Fatalf("%s: send %s: %v", c.session.RemoteAddr(), msg.Type(), err)
+	}
+}
+
+// expect 略過其他訊息(e.g. 在線人數、PI)，直到收到符合 match 的訊息
+func (c *pipeClient) expect(what string, match func(Message) bool) Message {
+	c.t.Helper()
+	c.session.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := c.session.Receive()
+		if err != nil {
+			c.t.Fatalf("%s: waiting for %s: %v", c.session.RemoteAddr(), what, err)
+		}
+		if errMsg, ok := msg.(*ErrorMsg); ok {
+			c.t.Fatalf("%s: waiting for %s: got error %+v", c.session.RemoteAddr(), what, errMsg)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+// expectRoomDetail 等待房間詳細內容，直到房間中有 players 位玩家
+func (c *pipeClient) expectRoomDetail(players int) *RoomDetailMsg {
+	c.t.Helper()
+	return c.expect("RD", func(msg Message) bool {
+		detail, ok := msg.(*RoomDetailMsg)
+		return ok && len(detail.Players) == players
+	}).(*RoomDetailMsg)
+}
+
+func (c *pipeClient) expectType(msgType MsgType) Message {
+	c.t.Helper()
+	return c.expect(msgType.String(), func(msg Message) bool {
+		return msg.Type() == msgType
+	})
+}
+
+// TestPipeHandshake PipeSession 與 TCP 連線走相同的握手流程，完成後進入大廳
+func TestPipeHandshake(t *testing.T) {
+	s := NewServer(WithLogger(nopLogger{}), WithHandshakeTimeout(time.Second))
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	alice, welcome := connectPipe(t, s, "alice")
+	if welcome.Version != ProtocolVersion || welcome.PlayerId == "" || welcome.SessionToken == "" || welcome.Scene != SceneLobby {
+		t.Fatalf("welcome = %+v, want version %d, player id, session token and lobby scene", welcome, ProtocolVersion)
+	}
+	alice.expectType(RoomInfoHeader)
+
+	//太舊的協定版本被拒絕
+	server, client := NewSessionPipe("old")
+	go s.AcceptSession(server)
+	old := &pipeClient{t: t, session: client}
+	old.send(&HelloMsg{Version: MinProtocolVersion - 1})
+	reject := old.expectType(HelloRejectHeader).(*HelloRejectMsg)
+	if reject.Code != RejectUnsupportedVersion {
+		t.Errorf("reject code = %d, want %d", reject.Code, RejectUnsupportedVersion)
+	}
+}
+
+// TestPipeBattle 兩位玩家從握手、創建與進入房間、準備到投降結束戰鬥，大廳與房間 goroutine 都由 -race 檢查
+func TestPipeBattle(t *testing.T) {
+	s := NewServer(WithLogger(nopLogger{}), WithHandshakeTimeout(time.Second))
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	alice, aliceWelcome := connectPipe(t, s, "alice")
+	bob, bobWelcome := connectPipe(t, s, "bob")
+	if aliceWelcome.PlayerId == "" || aliceWelcome.SessionToken == "" || aliceWelcome.Scene != SceneLobby {
+		t.Fatalf("welcome = %+v, want player id, session token and lobby scene", aliceWelcome)
+	}
+
+	alice.send(&PlayerNameMsg{Name: "Alice"})
+	alice.send(&CreateRoomMsg{RoomName: "room1"})
+	detail := alice.expectRoomDetail(1)
+	if detail.RoomName != "room1" || detail.Players[0].PlayerId != aliceWelcome.PlayerId || detail.Players[0].NickName != "Alice" {
+		t.Fatalf("room detail = %+v, want room1 with Alice", detail)
+	}
+	roomId := detail.RoomId
+
+	//大廳的房間列表出現新房間後進入
+	bob.expect("RL", func(msg Message) bool {
+		list, ok := msg.(*RoomListMsg)
+		return ok && len(list.Rooms) == 1 && list.Rooms[0].RoomId == roomId && list.Rooms[0].PlayerCount == 1
+	})
+	bob.send(&EnterRoomMsg{RoomId: roomId})
+	alice.expectRoomDetail(2)
+	detail = bob.expectRoomDetail(2)
+	if detail.Players[1].PlayerId != bobWelcome.PlayerId {
+		t.Fatalf("room detail = %+v, want bob as the second player", detail)
+	}
+
+	alice.send(&ReadyStartMsg{RoomId: roomId})
+	bob.send(&ReadyStartMsg{RoomId: roomId})
+	alice.expectType(StartBattleHeader)
+	bob.expectType(StartBattleHeader)
+
+	//倒數結束後開始收到戰鬥狀態
+	alice.expectType(BattleSituationHeader)
+	bob.expectType(BattleSituationHeader)
+	bob.send(&BattleActionMsg{Action: ActionUp})
+	bob.send(&GiveUpBattleMsg{RoomId: roomId, PlayerId: bobWelcome.PlayerId})
+
+	//戰鬥結束後雙方回到房間，都是未準備狀態
+	for _, c := range []*pipeClient{alice, bob} {
+		detail := c.expectRoomDetail(2)
+		for _, player := range detail.Players {
+			if player.ReadyStatus != 0 {
+				t.Errorf("%s ready status = %d after battle, want 0", player.PlayerId, player.ReadyStatus)
+			}
+		}
+		c.expectType(BattleOverHeader)
+	}
+
+	//回到房間後可以離開
+	bob.send(&LeaveRoomMsg{RoomId: roomId})
+	alice.expectRoomDetail(1)
+	bob.expect("RL", func(msg Message) bool {
+		list, ok := msg.(*RoomListMsg)
+		return ok && len(list.Rooms) == 1 && list.Rooms[0].PlayerCount == 1 && list.Rooms[0].RoomStatus == RoomStatusWaiting
+	})
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Session 一位 Client 的連線，大廳、房間與戰鬥只透過 Session 收發訊息，不需知道底層是 TCP、WebSocket 或測試用的 Pipe
+type Session interface {
+	// Send 以此連線協商的編碼送出訊息，可由多個 goroutine 同時呼叫
+	Send(msg Message) error
+	// Receive 讀取下一個 Client 訊息，只能由一個 goroutine 呼叫
+	Receive() (Message, error)
+	// SetReadDeadline 設定 Receive 的期限，零值代表不限時
+	SetReadDeadline(t time.Time) error
+	Close() error
+
+	// RemoteAddr Client 的位址，僅用於 Log
+	RemoteAddr() string
+	// Codec 此連線使用的編碼
+	Codec() Codec
+
+	// Get/Set 每條連線各自的附加資料
+	Get(key string) interface{}
+	Set(key string, value interface{})
+}
+
+// sessionMetadata 各 Session 共用的附加資料實作
+type sessionMetadata struct {
+	mutex sync.RWMutex
+	data  map[string]interface{}
+}
+
+func (m *sessionMetadata) Get(key string) interface{} {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.data[key]
+}
+
+func (m *sessionMetadata) Set(key string, value interface{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.data == nil {
+		m.data = make(map[string]interface{})
+	}
+	m.data[key] = value
+}
+
+// tcpSession TCP 連線，reader 需與偵測編碼時使用的是同一個
+type tcpSession struct {
+	sessionMetadata
+
+	conn   net.Conn
+	reader *bufio.Reader
+	codec  Codec
+
+	writeMutex sync.Mutex
+}
+
+func newTCPSession(conn net.Conn, reader *bufio.Reader, codec Codec) *tcpSession {
+	return &tcpSession{conn: conn, reader: reader, codec: codec}
+}
+
+func (s *tcpSession) Send(msg Message) error {
+	data, err := s.codec.EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err = s.conn.Write(data)
+	return err
+}
+
+func (s *tcpSession) Receive() (Message, error) {
+	return s.codec.ReadMessage(s.reader)
+}
+
+func (s *tcpSession) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *tcpSession) Close() error {
+	return s.conn.Close()
+}
+
+func (s *tcpSession) RemoteAddr() string {
+	return s.conn.RemoteAddr().String()
+}
+
+func (s *tcpSession) Codec() Codec {
+	return s.codec
+}
+
+// ErrSessionClosed Pipe 已關閉
+var ErrSessionClosed = errors.New("session closed")
+
+// PipeSession 記憶體中的 Session，不經過編碼直接傳遞訊息，方便不開 Socket 測試 Server
+type PipeSession struct {
+	sessionMetadata
+
+	name   string
+	inbox  chan Message
+	peer   *PipeSession
+	closed chan struct{}
+	once   *sync.Once
+
+	deadlineMutex sync.Mutex
+	deadline      time.Time
+}
+
+// NewSessionPipe 產生一對互相連接的 Session，一端交給 Server，另一端模擬 Client
+func NewSessionPipe(name string) (*PipeSession, *PipeSession) {
+	closed := make(chan struct{})
+	once := &sync.Once{}
+
+	server := &PipeSession{name: name, inbox: make(chan Message, 64), closed: closed, once: once}
+	client := &PipeSession{name: name, inbox: make(chan Message, 64), closed: closed, once: once}
+	server.peer = client
+	client.peer = server
+	return server, client
+}
+
+func (s *PipeSession) Send(msg Message) error {
+	select {
+	case <-s.closed:
+		return ErrSessionClosed
+	default:
+	}
+
+	select {
+	case s.peer.inbox <- msg:
+		return nil
+	case <-s.closed:
+		return ErrSessionClosed
+	}
+}
+
+func (s *PipeSession) Receive() (Message, error) {
+	var timeout <-chan time.Time
+	s.deadlineMutex.Lock()
+	if !s.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(s.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	s.deadlineMutex.Unlock()
+
+	select {
+	case msg := <-s.inbox:
+		return msg, nil
+	case <-s.closed:
+		return nil, io.EOF
+	case <-timeout:
+		//與 net.Conn 讀取逾時回傳相同的錯誤
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (s *PipeSession) SetReadDeadline(t time.Time) error {
+	s.deadlineMutex.Lock()
+	defer s.deadlineMutex.Unlock()
+	s.deadline = t
+	return nil
+}
+
+func (s *PipeSession) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *PipeSession) RemoteAddr() string {
+	return s.name
+}
+
+func (s *PipeSession) Codec() Codec {
+	return binaryCodec{}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"Pong/logger"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownGracePeriod 關閉伺服器時等待進行中戰鬥結束的時間，逾時的戰鬥會被中止
+const ShutdownGracePeriod = 30 * time.Second
+
+// 握手被拒絕的原因
+const RejectShuttingDown = 5 // 伺服器關閉中
+
+func (s *Server) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) != 0
+}
+
+// Shutdown 停止接受新連線並通知所有玩家伺服器即將關閉，等待進行中的戰鬥結束後關閉所有連線
+// ctx 到期時中止剩下的戰鬥並回傳 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.startJobs()
+
+	s.listenerMutex.Lock()
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	for server := range s.httpServers {
+		//已升級的 WebSocket 連線不受影響，通知玩家後才關閉
+		server.Close()
+	}
+	s.listenerMutex.Unlock()
+	s.logger.Info(logger.ServerShuttingDownMsg)
+
+	gracePeriod := ShutdownGracePeriod
+	if deadline, ok := ctx.Deadline(); ok {
+		gracePeriod = time.Until(deadline)
+	}
+	s.notifyAllPlayer(generateServerShutdownPayload("server shutting down", gracePeriod))
+
+	s.battles.close()
+	err := s.battles.wait(ctx)
+	if err != nil {
+		//遊戲迴圈收到取消後會記錄為中止的戰鬥
+		s.abortBattles()
+		s.battles.wait(context.Background())
+	}
+
+	s.closeAllSession()
+	s.stopJobs()
+	return err
+}
+
+// notifyAllPlayer 通知大廳、房間與戰鬥中所有玩家
+func (s *Server) notifyAllPlayer(payload Message) {
+	done := make(chan struct{})
+	if !s.toLobby(broadcastAll{msg: payload, done: done}) {
+		return
+	}
+	select {
+	case <-done:
+	case <-s.jobCtx.Done():
+	}
+}
+
+// closeAllSession 關閉所有玩家的連線，等待重連的計時隨房間 goroutine 停止
+func (s *Server) closeAllSession() {
+	done := make(chan struct{})
+	if !s.toLobby(closeAll{done: done}) {
+		return
+	}
+	select {
+	case <-done:
+	case <-s.jobCtx.Done():
+	}
+}
+
+// battleTracker 記錄進行中的戰鬥，關閉後不再接受新的戰鬥
+type battleTracker struct {
+	mutex  sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// add 開始一場戰鬥，已關閉時回傳 false
+func (t *battleTracker) add() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *battleTracker) done() {
+	t.wg.Done()
+}
+
+func (t *battleTracker) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closed = true
+}
+
+// wait 等待所有戰鬥結束，ctx 到期時回傳 ctx.Err()
+func (t *battleTracker) wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
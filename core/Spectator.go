@@ -0,0 +1,107 @@
+package core
+
+import (
+	"Pong/logger"
+	"fmt"
+)
+
+// SceneSpectate 在房間中觀戰，只接收戰鬥狀態，不能操作
+const SceneSpectate = "Spectate"
+
+// spectateRoom 大廳的玩家進入戰鬥中的房間觀戰，處理完後關閉 done
+type spectateRoom struct {
+	player *Player
+	msg    Message
+	done   chan struct{}
+}
+
+// watch 大廳的玩家開始觀戰，只有戰鬥中的房間可以觀戰
+func (r *Room) watch(player *Player, msg Message) {
+	if r.RoomStatus != RoomStatusPlaying {
+		r.server.sendError(player, ErrCodeNotPlaying, fmt.Sprintf("room %s is not playing", r.RoomId), msg)
+		return
+	}
+
+	player.SetScene(SceneSpectate)
+	r.spectators = append(r.spectators, player)
+	player.setRoom(r)
+
+	//通知大廳玩家(更新觀戰人數)
+	r.updateLobby()
+
+	//讓觀戰的玩家知道對戰雙方，之後的戰鬥狀態隨 BS 送出
+	r.server.sendMsg(player, generateRoomsDetailPayload(*r))
+	r.server.logger.Info(fmt.Sprintf(logger.SpectatorEnterRoomMsg, player.Id, r.RoomId))
+}
+
+// unwatch 觀戰的玩家回到大廳
+func (r *Room) unwatch(player *Player) {
+	r.removeSpectator(player.Id)
+
+	player.SetScene(SceneLobby)
+	player.setRoom(nil)
+
+	//通知大廳玩家(更新觀戰人數與房間List)
+	r.updateLobby()
+	r.server.logger.Info(fmt.Sprintf(logger.SpectatorLeaveRoomMsg, player.Id, r.RoomId))
+}
+
+// releaseSpectators 戰鬥結束或房間關閉時，觀戰的玩家全部回到大廳
+func (r *Room) releaseSpectators() {
+	for _, spectator := range append([]*Player(nil), r.spectators...) {
+		r.unwatch(spectator)
+	}
+}
+
+// handleSpectatorMessage 觀戰的玩家只能離開房間
+func (r *Room) handleSpectatorMessage(player *Player, msg Message) {
+	switch m := msg.(type) {
+	case *LeaveRoomMsg:
+		if !r.checkRoomId(player, m.RoomId, msg) {
+			break
+		}
+		r.unwatch(player)
+
+	default:
+		r.server.sendWrongSceneError(player, msg)
+	}
+}
+
+// notifySpectators 通知所有觀戰的玩家
+func (r *Room) notifySpectators(payload Message) {
+	for _, spectator := range r.spectators {
+		r.server.sendMsg(spectator, payload)
+	}
+}
+
+func (r *Room) isSpectator(playerId string) bool {
+	for _, spectator := range r.spectators {
+		if spectator.Id == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Room) removeSpectator(playerId string) {
+	for i, spectator := range r.spectators {
+		if spectator.Id == playerId {
+			r.spectators = append(r.spectators[:i], r.spectators[i+1:]...)
+			return
+		}
+	}
+}
+
+// spectate 請房間讓此玩家觀戰並等待處理完成，房間已關閉時回傳 false
+func (r *Room) spectate(player *Player, msg Message) bool {
+	done := make(chan struct{})
+	if !r.send(spectateRoom{player: player, msg: msg, done: done}) {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-r.done:
+		return false
+	}
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storeOpenTimeout 資料庫檔案被其他程序鎖住時等待的時間
+const storeOpenTimeout = 3 * time.Second
+
+var accountBucket = []byte("accounts")   // 帳號名稱(小寫) -> Account(JSON)
+var nickNameBucket = []byte("nicknames") // 暱稱(小寫) -> 帳號名稱(小寫)
+
+// Store 伺服器的本機資料庫(bbolt 單一檔案)，保存帳號、評分、戰績與比賽紀錄，可由多個 goroutine 同時使用
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore 開啟資料庫檔案，不存在時建立檔案與所需的 bucket
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: storeOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{accountBucket, nickNameBucket, matchBucket, playerMatchBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
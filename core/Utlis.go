@@ -4,9 +4,10 @@ import (
 	"fmt"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
+	"time"
 )
 
-func ReadProperties(env string) (string, string) {
+func ReadProperties(env string) (string, string, string) {
 	viper.SetConfigName(fmt.Sprintf("%s/%s", "properties", env))
 	viper.SetConfigType("properties")
 	viper.AddConfigPath("./")
@@ -18,5 +19,30 @@ func ReadProperties(env string) (string, string) {
 
 	host := cast.ToString(viper.Get("HOST_IP"))
 	port := cast.ToString(viper.Get("HOST_PORT"))
-	return host, port
+	wsPort := cast.ToString(viper.Get("WS_PORT"))
+	return host, port, wsPort
+}
+
+// ReadTimeouts 讀取握手與心跳逾時(秒)，需在 ReadProperties 之後呼叫，未設定時回傳 0
+func ReadTimeouts() (time.Duration, time.Duration) {
+	handshake := time.Duration(cast.ToInt(viper.Get("HANDSHAKE_TIMEOUT"))) * time.Second
+	heartbeat := time.Duration(cast.ToInt(viper.Get("HEARTBEAT_TIMEOUT"))) * time.Second
+	return handshake, heartbeat
+}
+
+// ReadStorePath 讀取帳號資料庫的檔案位置，需在 ReadProperties 之後呼叫，未設定時回傳空字串
+func ReadStorePath() string {
+	return cast.ToString(viper.Get("DB_PATH"))
+}
+
+// ReadAdminToken 讀取管理介面的 token，需在 ReadProperties 之後呼叫，未設定時回傳空字串
+func ReadAdminToken() string {
+	return cast.ToString(viper.Get("ADMIN_TOKEN"))
+}
+
+// ReadTickRates 讀取模擬與傳送的頻率(Hz)，需在 ReadProperties 之後呼叫，未設定時回傳 0
+func ReadTickRates() (int, int) {
+	tickRate := cast.ToInt(viper.Get("TICK_RATE"))
+	sendRate := cast.ToInt(viper.Get("SEND_RATE"))
+	return tickRate, sendRate
 }
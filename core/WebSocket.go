@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const WebSocketPath = "/ws"
+
+// WebSocket 子協定，決定此連線使用的編碼，未指定時使用 JSON
+const WebSocketProtocolJSON = "pong.json"
+const WebSocketProtocolBinary = "pong.binary"
+
+// maxWebSocketMessageSize 單一 WebSocket 訊息的最大長度，與 TCP 相同以 MaxFrameSize 限制(含封包長度欄位)
+const maxWebSocketMessageSize = frameLengthSize + MaxFrameSize
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{WebSocketProtocolJSON, WebSocketProtocolBinary},
+	//遊戲伺服器沒有 Cookie 驗證，允許任何來源的網頁 Client
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ServeWebSocket 在 listener 上接受瀏覽器的 WebSocket 連線，與 TCP 玩家共用同一個大廳與房間，Shutdown 後回傳 ErrServerClosed
+// 設定 admin token 時同時提供管理介面(AdminMatchesPath)
+func (s *Server) ServeWebSocket(listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebSocketPath, s.handleWebSocket)
+	mux.HandleFunc(AdminMatchesPath, s.handleAdminMatches)
+	server := &http.Server{Handler: mux}
+
+	s.listenerMutex.Lock()
+	if s.isShuttingDown() {
+		s.listenerMutex.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.httpServers[server] = struct{}{}
+	s.listenerMutex.Unlock()
+	s.startJobs()
+
+	s.logger.Info(fmt.Sprintf("WebSocket gateway 監聽 %s%s", listener.Addr(), WebSocketPath))
+
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ErrServerClosed
+	}
+	s.logger.Error(fmt.Sprintf("WebSocket gateway 停止: %v", err))
+	return err
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//Upgrade 失敗時已經回覆 HTTP 錯誤
+		s.logger.Warn(fmt.Sprintf("WebSocket upgrade 失敗 (ip:%s): %v", r.RemoteAddr, err))
+		return
+	}
+	s.logger.Info(fmt.Sprintf("Player已連線 (ws ip:%s)", ws.RemoteAddr().String()))
+
+	var codec Codec = jsonCodec{}
+	if ws.Subprotocol() == WebSocketProtocolBinary {
+		codec = binaryCodec{}
+	}
+
+	go s.acceptSession(newWsSession(ws, codec))
+}
+
+// wsSession WebSocket 連線，每個 WebSocket 訊息對應一個遊戲訊息
+type wsSession struct {
+	sessionMetadata
+
+	ws          *websocket.Conn
+	codec       Codec
+	messageType int // 送出的訊息類型，JSON 使用 TextMessage
+
+	writeMutex sync.Mutex // gorilla/websocket 同時只允許一個 writer
+}
+
+func newWsSession(ws *websocket.Conn, codec Codec) *wsSession {
+	messageType := websocket.BinaryMessage
+	if codec.Name() == CodecJSON {
+		messageType = websocket.TextMessage
+	}
+	//超過長度的訊息在緩衝前就中止連線
+	ws.SetReadLimit(maxWebSocketMessageSize)
+	return &wsSession{ws: ws, codec: codec, messageType: messageType}
+}
+
+func (s *wsSession) Send(msg Message) error {
+	data, err := s.codec.EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.ws.WriteMessage(s.messageType, data)
+}
+
+func (s *wsSession) Receive() (Message, error) {
+	_, data, err := s.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return s.codec.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+}
+
+func (s *wsSession) SetReadDeadline(t time.Time) error {
+	return s.ws.SetReadDeadline(t)
+}
+
+func (s *wsSession) Close() error {
+	return s.ws.Close()
+}
+
+func (s *wsSession) RemoteAddr() string {
+	return s.ws.RemoteAddr().String()
+}
+
+func (s *wsSession) Codec() Codec {
+	return s.codec
+}
@@ -4,9 +4,12 @@ go 1.18
 
 require (
 	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cast v1.5.0
 	github.com/spf13/viper v1.11.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
 
@@ -11,3 +11,40 @@ const PlayerCancelReadyMsg = "玩家 %s 按下取消準備 Room id:%s"
 const PlayerEnterRoomMsg = "%s 進入房間 RoomId: %s"
 
 const NotifyLobbyConnBrokenMsg = "有人斷線！通知大廳玩家 更新房間資訊！"
+
+const DecodeFrameFailedMsg = "無法解析 ip: %s 傳來的封包: %v"
+
+const HandshakeRejectedMsg = "拒絕 ip: %s 的連線，原因: %s"
+
+const EncodeMsgSkippedMsg = "ip: %s 的編碼不支援訊息 %s，略過傳送: %v"
+
+const PlayerWaitReconnectMsg = "玩家 %s 戰鬥中斷線 Room id:%s，等待重連 %s"
+const PlayerResumedMsg = "玩家 %s 已重新連線 (ip:%s)"
+const PlayerForfeitMsg = "玩家 %s 未重新連線，判負 Room id:%s"
+
+const ServerShuttingDownMsg = "伺服器關閉中，等待進行中的戰鬥結束..."
+const ServerStoppedMsg = "伺服器已關閉"
+const BattleAbortedMsg = "伺服器關閉，中止戰鬥 Room id:%s 比數 %d:%d"
+const AcceptFailedMsg = "接受連線失敗: %v"
+
+const SendQueueFullMsg = "玩家 %s 的送出佇列已滿，丟棄訊息 %s"
+const SlowClientEvictedMsg = "ip: %s 的送出佇列滿載超過 %s，中止連線"
+const TickStatsMsg = "Room id:%s 最近 %s 的 tick 統計: %v"
+const SendQueueDepthMsg = "玩家 %s 送出佇列深度 %d (最高 %d)"
+const BotAddedMsg = "電腦玩家 %s (%s) 加入房間 Room id:%s"
+const BotRemovedMsg = "電腦玩家 %s 離開房間 Room id:%s"
+const SpectatorEnterRoomMsg = "玩家 %s 開始觀戰 Room id:%s"
+const SpectatorLeaveRoomMsg = "玩家 %s 結束觀戰 Room id:%s"
+const QuickMatchJoinMsg = "玩家 %s 加入快速配對，目前排隊 %d 人"
+const QuickMatchCancelMsg = "玩家 %s 取消快速配對"
+const QuickMatchedMsg = "快速配對成功 %s vs %s Room id:%s"
+const RatingUpdatedMsg = "Room id:%s 評分更新 %s %d -> %d, %s %d -> %d"
+const AccountRegisteredMsg = "帳號 %s 註冊成功 (玩家 %s)"
+const AccountLoginMsg = "帳號 %s 登入 (玩家 %s)"
+const StoreFailedMsg = "資料庫存取失敗: %v"
+const MatchRecordedMsg = "保存比賽紀錄 #%d Room id:%s 結束原因 %s 時間 %s"
+const AdminMatchQueryMsg = "管理介面查詢比賽紀錄 (ip:%s) %s，共 %d 筆"
+const HeartbeatTimeoutMsg = "玩家 %s 已 %s 沒有任何訊息，判定斷線"
+const HeartBeatMsg = "連線仍然存活！玩家 %s 心跳"
+const PlayerLatencyMsg = "玩家 %s 延遲 %s (jitter %s)"
+const InvalidPongMsg = "玩家 %s 回覆的 PO 序號不正確: %d"